@@ -0,0 +1,268 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addChannels adds the channels in octopipe.yaml that don't exist yet, with their name as Id, so
+// steps can refer to them before put creates them
+func (r *processResources) addChannels(channels []channel) {
+	for _, c := range channels {
+		if _, err := getChannel(r.channels.Items, c.Name, ""); err != nil {
+			r.channels.Items = append(r.channels.Items, octopusChannel{ID: c.Name, Name: c.Name})
+		}
+	}
+}
+
+// splitActionPackage splits a channel rule package into the action name and package reference name
+func splitActionPackage(pkg string) (ap octopusActionPackage) {
+	if i := strings.LastIndex(pkg, ":"); i != -1 {
+		return octopusActionPackage{DeploymentAction: pkg[:i], PackageReference: pkg[i+1:]}
+	}
+
+	return octopusActionPackage{DeploymentAction: pkg}
+}
+
+// joinActionPackage is the reverse of splitActionPackage
+func joinActionPackage(ap octopusActionPackage) string {
+	if ap.PackageReference != "" {
+		return ap.DeploymentAction + ":" + ap.PackageReference
+	}
+
+	return ap.DeploymentAction
+}
+
+func hasActionPackage(steps []octopusDeploymentStep, ap octopusActionPackage) bool {
+	for _, s := range steps {
+		for _, a := range s.Actions {
+			if a.Name != ap.DeploymentAction {
+				continue
+			}
+			for _, pr := range a.Packages {
+				if pr.Name == ap.PackageReference {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// allItems asks Octopus for every item of a paged collection in one page
+const allItems = "take=2147483647"
+
+// getProjectChannels reads every channel of a project
+func getProjectChannels(projectID string) (cs octopusChannels) {
+	getOctopusData(&cs, apiURL("projects/"+projectID+"/channels?"+allItems))
+
+	return cs
+}
+
+// rulesForSteps returns the channels with only the version rules for packages the steps given deploy,
+// and the channels that had rules left out so they can be put again once the steps exist
+func rulesForSteps(channels []octopusChannel, steps []octopusDeploymentStep) (cs []octopusChannel, later []octopusChannel) {
+	for _, c := range channels {
+		rules := make([]octopusChannelRule, 0)
+		for _, rule := range c.Rules {
+			deployed := true
+			for _, ap := range rule.ActionPackages {
+				deployed = deployed && hasActionPackage(steps, ap)
+			}
+			if deployed {
+				rules = append(rules, rule)
+			}
+		}
+
+		if len(rules) != len(c.Rules) {
+			later = append(later, c)
+			c.Rules = rules
+		}
+		cs = append(cs, c)
+	}
+
+	return cs, later
+}
+
+// buildChannels creates the Octopus channels for the channels in octopipe.yaml. Version rules must
+// refer to packages deployed by the steps given
+func (r *processResources) buildChannels(channels []channel, l octopusLifecycles, steps []octopusDeploymentStep) (cs []octopusChannel) {
+	cs = make([]octopusChannel, 0)
+	defaults := 0
+
+	for _, c := range channels {
+		tc := octopusChannel{
+			Name:        c.Name,
+			Description: c.Description,
+			IsDefault:   c.IsDefault,
+			Rules:       make([]octopusChannelRule, 0),
+			TenantTags:  make([]string, 0),
+		}
+
+		if c.Lifecycle != "" {
+			lifecycle, err := getLifecycle(l, c.Lifecycle, "")
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			tc.LifecycleID = lifecycle.ID
+		}
+
+		for _, rule := range c.Rules {
+			if len(rule.Packages) == 0 {
+				logAndExitf("Version rule for channel '%s' has no packages", c.Name)
+			}
+			tr := octopusChannelRule{
+				VersionRange:   rule.VersionRange,
+				Tag:            rule.Tag,
+				ActionPackages: make([]octopusActionPackage, 0),
+			}
+			for _, pkg := range rule.Packages {
+				ap := splitActionPackage(pkg)
+				if !hasActionPackage(steps, ap) {
+					logAndExitf("Package '%s' in a version rule for channel '%s' is not deployed by the deployment process", pkg, c.Name)
+				}
+				tr.ActionPackages = append(tr.ActionPackages, ap)
+			}
+			tc.Rules = append(tc.Rules, tr)
+		}
+
+		for _, name := range c.TenantTags {
+			t, err := getTenantTag(r.tagSets, name)
+			if err != nil {
+				logAndExitf("%s for channel '%s'", err.Error(), c.Name)
+			}
+			tc.TenantTags = append(tc.TenantTags, t.CanonicalTagName)
+		}
+
+		if c.IsDefault {
+			defaults++
+		}
+		cs = append(cs, tc)
+	}
+
+	if defaults > 1 {
+		logAndExitf("Only one channel can be the default channel")
+	}
+
+	return cs
+}
+
+// channelRules formats the version rules of a channel one per line for display
+func channelRules(rules []octopusChannelRule) string {
+	lines := make([]string, 0)
+	for _, rule := range rules {
+		pkgs := make([]string, 0)
+		for _, ap := range rule.ActionPackages {
+			pkgs = append(pkgs, joinActionPackage(ap))
+		}
+		line := strings.Join(pkgs, ", ") + " " + rule.VersionRange
+		if rule.Tag != "" {
+			line = line + " tag " + rule.Tag
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func channelLifecycle(l octopusLifecycles, ID string) string {
+	if ID == "" {
+		return ""
+	}
+	lifecycle, err := getLifecycle(l, "", ID)
+	if err != nil {
+		return ID
+	}
+
+	return lifecycle.Name
+}
+
+func planChannel(current octopusChannel, desired octopusChannel, l octopusLifecycles) (changes []planChange) {
+	isDefault := func(c octopusChannel) string {
+		if c.IsDefault {
+			return "true"
+		}
+		return ""
+	}
+
+	changes = append(changes, planField("Channel", desired.Name, "Description", current.Description, desired.Description)...)
+	changes = append(changes, planField("Channel", desired.Name, "Lifecycle", channelLifecycle(l, current.LifecycleID), channelLifecycle(l, desired.LifecycleID))...)
+	changes = append(changes, planField("Channel", desired.Name, "IsDefault", isDefault(current), isDefault(desired))...)
+	changes = append(changes, planField("Channel", desired.Name, "Rules", channelRules(current.Rules), channelRules(desired.Rules))...)
+	changes = append(changes, planField("Channel", desired.Name, "TenantTags", strings.Join(current.TenantTags, ", "), strings.Join(desired.TenantTags, ", "))...)
+
+	return changes
+}
+
+// planChannels compares channels by name, channels in Octopus but not in octopipe.yaml are left alone
+func planChannels(current []octopusChannel, desired []octopusChannel, l octopusLifecycles) (changes []planChange) {
+	for _, c := range desired {
+		ec, err := getChannel(current, c.Name, "")
+		if err != nil {
+			added := planChannel(octopusChannel{}, c, l)
+			if len(added) == 0 {
+				added = []planChange{{Resource: "Channel", ID: c.Name, Action: "add"}}
+			}
+			changes = append(changes, withResourceAction(added, "add")...)
+			continue
+		}
+
+		changes = append(changes, withResourceAction(planChannel(ec, c, l), "change")...)
+	}
+
+	return changes
+}
+
+// putChannels creates or updates the channels of a project, matching them by name. The default
+// channel is put first so the project always has one
+func putChannels(projectID string, channels []octopusChannel) {
+	current := getProjectChannels(projectID)
+
+	ordered := make([]octopusChannel, 0)
+	for _, c := range channels {
+		if c.IsDefault {
+			ordered = append([]octopusChannel{c}, ordered...)
+		} else {
+			ordered = append(ordered, c)
+		}
+	}
+
+	for _, c := range ordered {
+		c.ProjectID = projectID
+		if ec, err := getChannel(current.Items, c.Name, ""); err == nil {
+			c.ID = ec.ID
+			putOctopusData(&c, apiURL("channels/"+c.ID))
+		} else {
+			postOctopusData(&c, apiURL("channels"))
+		}
+		fmt.Println("Put Channel " + c.Name)
+	}
+}
+
+// exportChannels returns the octopipe.yaml channels for the channels of a project
+func exportChannels(channels []octopusChannel, l octopusLifecycles) (cs []channel) {
+	for _, c := range channels {
+		tc := channel{
+			Name:        c.Name,
+			Description: c.Description,
+			Lifecycle:   channelLifecycle(l, c.LifecycleID),
+			IsDefault:   c.IsDefault,
+		}
+
+		for _, rule := range c.Rules {
+			tr := channelRule{VersionRange: rule.VersionRange, Tag: rule.Tag}
+			for _, ap := range rule.ActionPackages {
+				tr.Packages = append(tr.Packages, joinActionPackage(ap))
+			}
+			tc.Rules = append(tc.Rules, tr)
+		}
+
+		if len(c.TenantTags) != 0 {
+			tc.TenantTags = c.TenantTags
+		}
+		cs = append(cs, tc)
+	}
+
+	return cs
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v2"
@@ -38,6 +39,7 @@ Examples
 
 octopipe create
 octopipe create -i My.Octopus.Project
+octopipe create -i My.Octopus.Project --space Platform
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -50,166 +52,15 @@ octopipe create -i My.Octopus.Project
 				logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
 			}
 
-			// Project
-			slug := getProjectSlug(pn)
-
-			p := octopusProject{}
-			v := octopusVariableSet{}
-			d := octopusDeploymentProcess{}
-			l := octopusLifecycles{}
-			g := octopusProjectGroups{}
-
-			getOctopusData(&p, uri+"/api/projects/"+slug)
-			getOctopusData(&v, uri+"/api/variables/"+p.VariableSetID)
-			getOctopusData(&d, uri+"/api/deploymentprocesses/"+p.DeploymentProcessID)
-			getOctopusData(&l, uri+"/api/lifecycles/all")
-			getOctopusData(&g, uri+"/api/projectgroups/all")
-
-			tl, _ := getLifecycle(l, "", p.LifecycleID)
-			tg, _ := getProjectGroup(g, "", p.ProjectGroupID)
-
-			ds := v.ScopeValues.makeScopeDataSet()
-
-			// Variables
-			vss := []variable{}
-
-			for _, vs := range v.Variables {
-				exist := false
-				for i, sv := range vss {
-					if sv.Name == vs.Name {
-						exist = true
-						tv := variable{}
-						tv.Name = vs.Name
-						tv.Description = vs.Description
-						if vs.Type != "String" {
-							tv.Type = vs.Type
-						}
-
-						values := sv.ScopedValues
-
-						if vs.Scope != nil {
-							scvalue := make(map[string]string)
-							for sc, es := range vs.Scope {
-								_, scopeNames, _ := v.ScopeValues.getScope(ds, "", es, sc)
-								j := ""
-								for _, sc := range scopeNames {
-									j = j + sc + ","
-								}
-								j = strings.TrimRight(j, ",")
-								scvalue[sc] = j
-							}
-							scvalue["value"] = vs.Value
-							values = append(values, scvalue)
-						} else {
-							scvalue := make(map[string]string)
-							scvalue["value"] = vs.Value
-							values = append(values, scvalue)
-						}
-
-						tv.ScopedValues = values
-
-						vss[i] = tv
-
-						break
-					}
-				}
-				if exist {
-					continue
-				}
-
-				tv := variable{}
-				tv.Name = vs.Name
-				tv.Description = vs.Description
-				if vs.Type != "String" {
-					tv.Type = vs.Type
-				}
-
-				if len(vs.Scope) != 0 {
-					values := make([]map[string]string, 0)
-					scvalue := make(map[string]string)
-					for sc, es := range vs.Scope {
-						_, scopeNames, _ := v.ScopeValues.getScope(ds, "", es, sc)
-						j := ""
-						for _, sc := range scopeNames {
-							j = j + sc + ","
-						}
-						j = strings.TrimRight(j, ",")
-						scvalue[sc] = j
-					}
-					scvalue["value"] = vs.Value
-					values = append(values, scvalue)
-					tv.ScopedValues = values
-				} else {
-					tv.Value = vs.Value
-				}
-
-				vss = append(vss, tv)
-			}
-
-			// Deployment process
-			dss := process{}
-			dsa := []step{}
-
-			_, err := os.Stat("scripts")
-			if os.IsNotExist(err) {
-				os.Mkdir("scripts", 0644)
-			}
-
-			ext := make(map[string]string)
-			ext["PowerShell"] = "ps1"
-			ext["Bash"] = "sh"
-			ext["FSharp"] = "f"
-			ext["Csharp"] = "c"
-
-			for _, s := range d.Steps {
-				for _, a := range s.Actions {
-					if a.ActionType == "Octopus.Script" {
-						script := a.Properties["Octopus.Action.Script.ScriptBody"]
-						filename := getProjectSlug(a.Name)
-						ffilename := "scripts/" + filename + "." + ext[a.Properties["Octopus.Action.Script.Syntax"]]
-
-						err = ioutil.WriteFile(ffilename, []byte(script), 0644)
-						if err != nil {
-							logAndExitf("Failed to write deployment script to disk:\n%s\n", err.Error())
-						}
-						ts := step{}
-						ts.Name = a.Name
-						ts.Type = a.Properties["Octopus.Action.Script.Syntax"]
-						ts.File = ffilename
-
-						dsa = append(dsa, ts)
-					}
-				}
-			}
-
-			dss.Steps = dsa
-
-			op := octopipe{}
-
-			op.Project.Name = p.Name
-			op.Project.Description = p.Description
-			op.Project.Tenanted = p.TenantedDeploymentMode
-			op.Project.Lifecycle = tl.Name
-			op.Project.ProjectGroup = tg.Name
+			selectSpace("")
 
-			op.Variables = vss
-
-			op.Process = dss
-
-			info, _ := os.Lstat("octopipe.yaml")
-			if info != nil {
-				logAndExitf("octopipe.yaml already exists, will not overwrite")
-			}
-
-			contents, err := yaml.Marshal(op)
+			ps := octopusProjects{}
+			getOctopusData(&ps, apiURL("projects/all"))
+			p, err := getProject(ps, pn, "")
 			if err != nil {
-				logAndExitf("Failed to serialize yaml data:\n%s\n", err.Error())
-			}
-
-			err = ioutil.WriteFile("octopipe.yaml", contents, 0644)
-			if err != nil {
-				logAndExitf("Failed to write file to disk:\n%s\n", err.Error())
+				logAndExitf(err.Error())
 			}
+			exportProject(p.ID, ".")
 
 			os.Exit(0)
 		}
@@ -219,14 +70,9 @@ octopipe create -i My.Octopus.Project
 			logAndExitf("octopipe.yaml already exists, will not overwrite")
 		}
 
-		values := make([]map[string]string, 0)
-		values1 := make(map[string]string)
-		values2 := make(map[string]string)
-		values1["Environment"] = "Dev,Test"
-		values1["value"] = "aks-devtest-rg"
-		values2["Environment"] = "Production"
-		values2["Machine"] = "deploynode01"
-		values2["value"] = "aks-pd-rg"
+		values := make([]scopedValue, 0)
+		values1 := scopedValue{Value: "aks-devtest-rg", Scope: map[string]string{"Environment": "Dev,Test"}}
+		values2 := scopedValue{Value: "aks-pd-rg", Scope: map[string]string{"Environment": "Production", "Machine": "deploynode01"}}
 		values = append(values, values1, values2)
 
 		variables := make([]variable, 0)
@@ -237,8 +83,8 @@ octopipe create -i My.Octopus.Project
 		variables = append(variables, variable{Name: "variable3", Value: "octopusdeploy-account", Type: "AzureAccount", Description: "Account used for deployments"})
 
 		steps := make([]step, 0)
-		steps = append(steps, step{Name: "Init", Type: "PowerShell", File: "scripts/init.ps1"})
-		steps = append(steps, step{Name: "Deploy VM", Type: "PowerShell", File: "scripts/deploy.ps1"})
+		steps = append(steps, step{action: action{Name: "Init", Type: "PowerShell", File: "scripts/init.ps1"}})
+		steps = append(steps, step{action: action{Name: "Deploy VM", Type: "PowerShell", File: "scripts/deploy.ps1"}})
 
 		op := octopipe{
 			Project: project{
@@ -305,3 +151,222 @@ func init() {
 	// createCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	createCmd.Flags().StringP("import", "i", "", "Create an octopipe.yaml file from an existing project, supply the project name")
 }
+
+// exportProject writes the octopipe.yaml file of an Octopus project, and the scripts of its steps, to
+// the directory base
+func exportProject(projectID string, base string) {
+
+	info, _ := os.Lstat(filepath.Join(base, "octopipe.yaml"))
+	if info != nil {
+		logAndExitf("%s already exists, will not overwrite", filepath.Join(base, "octopipe.yaml"))
+	}
+
+	// Project
+	p := octopusProject{}
+	v := octopusVariableSet{}
+	d := octopusDeploymentProcess{}
+	l := octopusLifecycles{}
+	g := octopusProjectGroups{}
+
+	getOctopusData(&p, apiURL("projects/"+projectID))
+	getOctopusData(&v, apiURL("variables/"+p.VariableSetID))
+	getOctopusData(&d, apiURL("deploymentprocesses/"+p.DeploymentProcessID))
+	getOctopusData(&l, apiURL("lifecycles/all"))
+	getOctopusData(&g, apiURL("projectgroups/all"))
+
+	tl, _ := getLifecycle(l, "", p.LifecycleID)
+	tg, _ := getProjectGroup(g, "", p.ProjectGroupID)
+
+	exportProjectSettings(p)
+
+	ds := v.ScopeValues.makeScopeDataSet()
+
+	// Variables
+	vss := []variable{}
+
+	for _, vs := range v.Variables {
+		if vs.IsSensitive {
+			exportNotice("Variable '%s' is sensitive so its value isn't exported, add it with a secret reference", vs.Name)
+		}
+
+		exist := false
+		for i, sv := range vss {
+			if sv.Name == vs.Name {
+				exist = true
+				tv := variable{}
+				tv.Name = vs.Name
+				tv.Description = vs.Description
+				tv.Sensitive = sv.Sensitive || vs.IsSensitive
+				if vs.Type != "String" && vs.Type != "Sensitive" {
+					tv.Type = vs.Type
+				}
+
+				// An unscoped value seen first moves to the scoped values with an empty scope and
+				// its prompt, so it is kept even when it is sensitive or prompted and has no value
+				values := sv.ScopedValues
+				if values == nil {
+					values = []scopedValue{{Value: sv.Value, Scope: make(map[string]string), Prompt: sv.Prompt}}
+				}
+
+				scvalue := scopedValue{Scope: make(map[string]string), Prompt: exportPrompt(vs)}
+				for sc, es := range vs.Scope {
+					_, scopeNames, _ := v.ScopeValues.getScope(ds, "", es, sc)
+					scvalue.Scope[sc] = strings.Join(scopeNames, ",")
+				}
+				if !vs.IsSensitive {
+					scvalue.Value = escapeValue(vs.Value)
+				}
+				values = append(values, scvalue)
+
+				tv.ScopedValues = values
+
+				vss[i] = tv
+
+				break
+			}
+		}
+		if exist {
+			continue
+		}
+
+		tv := variable{}
+		tv.Name = vs.Name
+		tv.Description = vs.Description
+		tv.Sensitive = vs.IsSensitive
+		if vs.Type != "String" && vs.Type != "Sensitive" {
+			tv.Type = vs.Type
+		}
+
+		if len(vs.Scope) != 0 {
+			scvalue := scopedValue{Scope: make(map[string]string), Prompt: exportPrompt(vs)}
+			for sc, es := range vs.Scope {
+				_, scopeNames, _ := v.ScopeValues.getScope(ds, "", es, sc)
+				scvalue.Scope[sc] = strings.Join(scopeNames, ",")
+			}
+			if !vs.IsSensitive {
+				scvalue.Value = escapeValue(vs.Value)
+			}
+			tv.ScopedValues = []scopedValue{scvalue}
+		} else {
+			tv.Prompt = exportPrompt(vs)
+			if !vs.IsSensitive {
+				tv.Value = escapeValue(vs.Value)
+			}
+		}
+
+		vss = append(vss, tv)
+	}
+
+	// Deployment process
+	dss := process{}
+
+	err := os.MkdirAll(filepath.Join(base, "scripts"), 0755)
+	if err != nil {
+		logAndExitf("Failed to create scripts:\n%s", err.Error())
+	}
+
+	r := getProcessResources(p.ID)
+	dss.Steps = r.exportDeploymentSteps(d.Steps, base, "scripts")
+
+	op := octopipe{}
+
+	op.Space = spaceName
+	op.Project.Name = p.Name
+	op.Project.Description = p.Description
+	op.Project.Tenanted = p.TenantedDeploymentMode
+	op.Project.Lifecycle = tl.Name
+	op.Project.ProjectGroup = tg.Name
+	op.Project.Channels = exportChannels(r.channels.Items, l)
+	op.Project.Templates = exportTemplates(p.Templates)
+
+	op.Variables = vss
+
+	op.Process = dss
+
+	op.Runbooks = r.exportRunbooks(base, p.ID)
+
+	ts := octopusProjectTriggers{}
+	getOctopusData(&ts, apiURL("projects/"+p.ID+"/triggers"))
+	op.Triggers = r.exportTriggers(ts.Items)
+
+	if p.TenantedDeploymentMode != "Untenanted" {
+		op.Tenants = r.exportTenants(p.ID, p.IncludedLibraryVariableSetIds)
+	}
+
+	lvs := octopusLibraryVariableSets{}
+	getOctopusData(&lvs, apiURL("libraryvariablesets/all"))
+	op.Project.Include = exportIncludes(p.IncludedLibraryVariableSetIds, lvs)
+	op.ScriptModules = exportScriptModules(base, p.IncludedLibraryVariableSetIds, lvs)
+
+	contents, err := yaml.Marshal(op)
+	if err != nil {
+		logAndExitf("Failed to serialize yaml data:\n%s\n", err.Error())
+	}
+
+	err = ioutil.WriteFile(filepath.Join(base, "octopipe.yaml"), contents, 0644)
+	if err != nil {
+		logAndExitf("Failed to write file to disk:\n%s\n", err.Error())
+	}
+}
+
+// defaultVersionTemplate is the release version template of a new Octopus project
+const defaultVersionTemplate = "#{Octopus.Version.LastMajor}.#{Octopus.Version.LastMinor}.#{Octopus.Version.NextPatch}"
+
+// exportProjectSettings reports the settings of a project that octopipe.yaml can't represent
+// when they aren't the default for a new project
+func exportProjectSettings(p octopusProject) {
+	ps := octopusProjectSettings{}
+	getOctopusData(&ps, apiURL("projects/"+p.ID))
+
+	if ps.IsDisabled {
+		exportNotice("Project '%s' is disabled which octopipe can't export", p.Name)
+	}
+	if vs := ps.VersioningStrategy; vs.DonorPackage != nil {
+		exportNotice("Project '%s' takes its release versions from package step '%s' which octopipe can't export", p.Name, vs.DonorPackage.DeploymentAction)
+	} else if vs.Template != "" && vs.Template != defaultVersionTemplate {
+		exportNotice("Project '%s' has release version template '%s' which octopipe can't export", p.Name, vs.Template)
+	}
+	if ps.ReleaseNotesTemplate != "" {
+		exportNotice("Project '%s' has a release notes template which octopipe can't export", p.Name)
+	}
+	if ps.DefaultGuidedFailureMode != "" && ps.DefaultGuidedFailureMode != "EnvironmentDefault" {
+		exportNotice("Project '%s' has guided failure mode %s which octopipe can't export", p.Name, ps.DefaultGuidedFailureMode)
+	}
+	if ps.DefaultToSkipIfAlreadyInstalled {
+		exportNotice("Project '%s' skips packages that are already installed which octopipe can't export", p.Name)
+	}
+	if ps.AutoCreateRelease {
+		exportNotice("Project '%s' creates releases automatically which octopipe can't export", p.Name)
+	}
+	if ps.DiscreteChannelRelease {
+		exportNotice("Project '%s' treats releases in each channel separately which octopipe can't export", p.Name)
+	}
+
+	cp := ps.ProjectConnectivityPolicy
+	if cp.SkipMachineBehavior != "" && cp.SkipMachineBehavior != "None" {
+		exportNotice("Project '%s' has skip machine behavior %s which octopipe can't export", p.Name, cp.SkipMachineBehavior)
+	}
+	if len(cp.TargetRoles) != 0 {
+		exportNotice("Project '%s' only skips targets in roles %s which octopipe can't export", p.Name, strings.Join(cp.TargetRoles, ", "))
+	}
+	if cp.AllowDeploymentsToNoTargets {
+		exportNotice("Project '%s' allows deployments with no targets which octopipe can't export", p.Name)
+	}
+	if cp.ExcludeUnhealthyTargets {
+		exportNotice("Project '%s' excludes unhealthy targets which octopipe can't export", p.Name)
+	}
+}
+
+// exportPrompt returns the octopipe.yaml prompt for a variable, leaving out the label if it is the variable name
+func exportPrompt(v octopusVariable) *prompt {
+	if v.Prompt == nil {
+		return nil
+	}
+
+	p := &prompt{Label: v.Prompt.Label, Description: v.Prompt.Description, Required: v.Prompt.Required}
+	if p.Label == v.Name {
+		p.Label = ""
+	}
+
+	return p
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// deployCmd represents the deploy command
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Deploy a release of the project in octopipe.yaml",
+	Long: `
+Use deploy to deploy a release of the project in octopipe.yaml
+to an environment, optionally for a tenant. The release is
+its version or the Id printed by release create
+
+Pass --wait to follow the deployment, printing its task log
+as it runs. Deploy then exits non-zero if the deployment fails
+
+Usage:
+
+octopipe deploy --release 1.2.0 --environment DevTest
+octopipe deploy --release Releases-123 --environment Production --tenant Contoso --wait
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		release, _ := cmd.Flags().GetString("release")
+		environment, _ := cmd.Flags().GetString("environment")
+		tenantName, _ := cmd.Flags().GetString("tenant")
+		wait, _ := cmd.Flags().GetBool("wait")
+
+		if apiKey == "" || uri == "" {
+			logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
+		}
+		if release == "" || environment == "" {
+			logAndExitf("The release and environment to deploy to must be specified with --release and --environment")
+		}
+
+		var op octopipe
+		op.importOctopipeFile()
+		selectSpace(op.Space)
+
+		p := octopusProject{}
+		getOctopusData(&p, apiURL("projects/"+getProjectSlug(op.Project.Name)))
+
+		r := octopusRelease{}
+		if strings.HasPrefix(release, "Releases-") {
+			getOctopusData(&r, apiURL("releases/"+release))
+			if r.ProjectID != p.ID {
+				logAndExitf("Release %s is not a release of project %s", release, p.Name)
+			}
+		} else {
+			getOctopusData(&r, apiURL("projects/"+p.ID+"/releases/"+release))
+		}
+
+		es := octopusEnvironments{}
+		getOctopusData(&es, apiURL("environments/all"))
+		e, err := getEnvironment(es, environment, "")
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+
+		d := octopusDeployment{ReleaseID: r.ID, EnvironmentID: e.ID}
+		if tenantName != "" {
+			d.TenantID = getTenantID(tenantName)
+		}
+		postOctopusData(&d, apiURL("deployments"))
+		fmt.Printf("Deploying release %s to %s (%s)\n", r.Version, e.Name, d.ID)
+
+		if wait {
+			waitForTask(d.TaskID)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(deployCmd)
+
+	deployCmd.Flags().StringP("release", "r", "", "The version or Id of the release to deploy")
+	deployCmd.Flags().StringP("environment", "e", "", "The name of the environment to deploy to")
+	deployCmd.Flags().StringP("tenant", "t", "", "The name of the tenant to deploy for")
+	deployCmd.Flags().BoolP("wait", "w", false, "Wait for the deployment to finish, printing its task log")
+}
+
+// getTenantID finds the Id of a tenant by name
+func getTenantID(name string) string {
+	ots := octopusTenants{}
+	getOctopusData(&ots, apiURL("tenants/all"))
+	t, err := getTenant(ots, name, "")
+	if err != nil {
+		logAndExitf(err.Error())
+	}
+
+	return t.ID
+}
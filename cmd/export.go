@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export the projects of a project group or space",
+	Long: `
+Use export to create a repository layout from the projects
+of a project group, or every project in the space with --all.
+Each project is written to a folder named after its slug in
+the --out directory, with its octopipe.yaml and scripts
+
+Export lists the steps and settings of each project that
+octopipe can't represent once every project is written, such
+as sensitive values, project settings like guided failure and
+step settings like containers and notes
+
+Usage:
+
+octopipe export --group "Platform" --out ./octopus
+octopipe export --all --out ./octopus --space Platform
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		group, _ := cmd.Flags().GetString("group")
+		all, _ := cmd.Flags().GetBool("all")
+		out, _ := cmd.Flags().GetString("out")
+
+		if apiKey == "" || uri == "" {
+			logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
+		}
+		if (group == "") == !all {
+			logAndExitf("Specify the project group to export with --group, or --all to export every project")
+		}
+
+		selectSpace("")
+
+		ps := octopusProjects{}
+		getOctopusData(&ps, apiURL("projects/all"))
+
+		if group != "" {
+			g := octopusProjectGroups{}
+			getOctopusData(&g, apiURL("projectgroups/all"))
+			pg, err := getProjectGroup(g, group, "")
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+
+			grouped := octopusProjects{}
+			for _, p := range ps {
+				if p.ProjectGroupID == pg.ID {
+					grouped = append(grouped, p)
+				}
+			}
+			ps = grouped
+		}
+
+		if len(ps) == 0 {
+			logAndExitf("No projects to export")
+		}
+
+		// Every folder is checked before anything is written so export doesn't stop partway through
+		dirs := make(map[string]string)
+		for _, p := range ps {
+			dir := filepath.Join(out, exportFolder(p))
+			if other, ok := dirs[dir]; ok {
+				logAndExitf("Projects '%s' and '%s' would both be exported to %s", other, p.Name, dir)
+			}
+			dirs[dir] = p.Name
+
+			file := filepath.Join(dir, "octopipe.yaml")
+			info, _ := os.Lstat(file)
+			if info != nil {
+				logAndExitf("%s already exists, will not overwrite", file)
+			}
+		}
+
+		notices := make(map[string][]string)
+		for _, p := range ps {
+			dir := filepath.Join(out, exportFolder(p))
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				logAndExitf("Failed to create %s:\n%s", dir, err.Error())
+			}
+
+			exportNotices = nil
+			exportProject(p.ID, dir)
+			notices[p.Name] = exportNotices
+
+			fmt.Println("Exported " + p.Name + " to " + dir)
+		}
+
+		for _, p := range ps {
+			if len(notices[p.Name]) == 0 {
+				continue
+			}
+
+			fmt.Printf("\n%s has steps or settings octopipe couldn't export:\n", p.Name)
+			for _, notice := range notices[p.Name] {
+				fmt.Println("  " + notice)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("group", "g", "", "The name of the project group to export")
+	exportCmd.Flags().BoolP("all", "a", false, "Export every project in the space")
+	exportCmd.Flags().StringP("out", "o", ".", "The directory to write a folder for each project to")
+}
+
+// exportFolder returns the folder a project is exported to, its slug in Octopus
+func exportFolder(p octopusProject) string {
+	if p.Slug != "" {
+		return p.Slug
+	}
+
+	return getProjectSlug(p.Name)
+}
@@ -16,6 +16,15 @@ func logAndExitf(message string, a ...interface{}) {
 	os.Exit(1)
 }
 
+// exportNotices are the steps and settings octopipe couldn't represent in the last project exported
+var exportNotices []string
+
+func exportNotice(message string, a ...interface{}) {
+	notice := fmt.Sprintf(message, a...)
+	fmt.Println(notice)
+	exportNotices = append(exportNotices, notice)
+}
+
 func doOctopusRequest(body []byte, uri string, method string) (responsebody []byte, status int) {
 
 	httpreq, _ := http.NewRequest(method, uri, nil)
@@ -78,7 +87,7 @@ func verifyVariableType(svtype variable) (vtype string, err error) {
 	return "", errors.New("Variable type '" + svtype.Type + "' for variable '" + svtype.Name + "' is not valid. Valid types are " + errorstring)
 }
 
-func verifySyntaxType(satype step) (atype string, err error) {
+func verifySyntaxType(satype action) (atype string, err error) {
 	for _, validType := range validScriptSyntaxTypes {
 		if validType == satype.Type {
 			return satype.Type, nil
@@ -141,6 +150,15 @@ func getLifecycle(ls []octopusLifecycle, name string, ID string) (l octopusLifec
 	return octopusLifecycle{}, errors.New("Lifecycle with name " + name + " not found")
 }
 
+func getProject(ps []octopusProject, name string, ID string) (p octopusProject, err error) {
+	for _, p := range ps {
+		if p.Name == name || p.ID == ID {
+			return p, nil
+		}
+	}
+	return octopusProject{}, errors.New("Project with name " + name + " not found")
+}
+
 func getProjectGroup(pgs []octopusProjectGroup, name string, ID string) (pg octopusProjectGroup, err error) {
 	for _, pg := range pgs {
 		if pg.Name == name || pg.ID == ID {
@@ -149,3 +167,270 @@ func getProjectGroup(pgs []octopusProjectGroup, name string, ID string) (pg octo
 	}
 	return octopusProjectGroup{}, errors.New("Project group with name " + name + " not found")
 }
+
+func getFeed(fs []octopusFeed, name string, ID string) (f octopusFeed, err error) {
+	for _, f := range fs {
+		if f.Name == name || f.ID == ID {
+			return f, nil
+		}
+	}
+	return octopusFeed{}, errors.New("Feed with name " + name + " not found")
+}
+
+func getAccount(as []octopusAccount, name string, ID string) (a octopusAccount, err error) {
+	for _, a := range as {
+		if a.Name == name || a.ID == ID {
+			return a, nil
+		}
+	}
+	return octopusAccount{}, errors.New("Account with name " + name + " not found")
+}
+
+func getWorkerPool(wps []octopusWorkerPool, name string, ID string) (wp octopusWorkerPool, err error) {
+	for _, wp := range wps {
+		if wp.Name == name || wp.ID == ID {
+			return wp, nil
+		}
+	}
+	return octopusWorkerPool{}, errors.New("Worker pool with name " + name + " not found")
+}
+
+func getDefaultWorkerPool(wps []octopusWorkerPool) (wp octopusWorkerPool) {
+	for _, wp := range wps {
+		if wp.IsDefault {
+			return wp
+		}
+	}
+	return octopusWorkerPool{ID: "WorkerPools-1"}
+}
+
+func verifyRunOnType(s action) (runOn string, err error) {
+	for _, validType := range validRunOnTypes {
+		if validType == s.RunOn {
+			return s.RunOn, nil
+		}
+	}
+
+	var errorstring string
+	for _, vro := range validRunOnTypes {
+		errorstring = errorstring + vro + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", errors.New("Run on '" + s.RunOn + "' for process step '" + s.Name + "' is not valid. Valid values are " + errorstring)
+}
+
+func getEnvironment(es []octopusEnvironment, name string, ID string) (e octopusEnvironment, err error) {
+	for _, e := range es {
+		if e.Name == name || e.ID == ID {
+			return e, nil
+		}
+	}
+	return octopusEnvironment{}, errors.New("Environment with name " + name + " not found")
+}
+
+func getChannel(cs []octopusChannel, name string, ID string) (c octopusChannel, err error) {
+	for _, c := range cs {
+		if c.Name == name || c.ID == ID {
+			return c, nil
+		}
+	}
+	return octopusChannel{}, errors.New("Channel with name " + name + " not found")
+}
+
+func getTenantTag(ts []octopusTagSet, name string) (t octopusTag, err error) {
+	for _, set := range ts {
+		for _, t := range set.Tags {
+			if t.CanonicalTagName == name {
+				return t, nil
+			}
+		}
+	}
+	return octopusTag{}, errors.New("Tenant tag with name " + name + " not found, tenant tags are in the format 'Tag Set/Tag'")
+}
+
+func verifyConditionType(s step) (condition string, expression string, err error) {
+	if strings.HasPrefix(s.Condition, "#{") {
+		return "Variable", s.Condition, nil
+	}
+	for _, validType := range validConditionTypes {
+		if validType == s.Condition {
+			return s.Condition, "", nil
+		}
+	}
+
+	var errorstring string
+	for _, vc := range validConditionTypes {
+		errorstring = errorstring + vc + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", "", errors.New("Condition '" + s.Condition + "' for process step '" + s.Name + "' is not valid. Valid conditions are " + errorstring + " or a variable expression such as '#{MyVariable}'")
+}
+
+func verifyStartTriggerType(s step) (trigger string, err error) {
+	if trigger, ok := validStartTriggerTypes[s.StartTrigger]; ok {
+		return trigger, nil
+	}
+
+	return "", errors.New("Start trigger '" + s.StartTrigger + "' for process step '" + s.Name + "' is not valid. Valid start triggers are wait, parallel")
+}
+
+func getLibraryVariableSet(lvs []octopusLibraryVariableSet, name string, ID string) (lv octopusLibraryVariableSet, err error) {
+	for _, lv := range lvs {
+		if lv.Name == name || lv.ID == ID {
+			return lv, nil
+		}
+	}
+	return octopusLibraryVariableSet{}, errors.New("Library variable set with name " + name + " not found")
+}
+
+func verifyScriptModuleSyntaxType(m scriptModule) (syntax string, err error) {
+	for _, validType := range validScriptSyntaxTypes {
+		if validType == m.Syntax {
+			return m.Syntax, nil
+		}
+	}
+
+	var errorstring string
+	for _, vsyn := range validScriptSyntaxTypes {
+		errorstring = errorstring + vsyn + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", errors.New("Script syntax type '" + m.Syntax + "' for script module '" + m.Name + "' is not valid. Syntax types are case sensitive. Valid types are " + errorstring)
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getSpace(ss []octopusSpace, name string, ID string) (s octopusSpace, err error) {
+	for _, s := range ss {
+		if s.Name == name || s.ID == ID {
+			return s, nil
+		}
+	}
+	return octopusSpace{}, errors.New("Space with name " + name + " not found")
+}
+
+// selectSpace routes requests to the named space. The --space flag or OCTOPUS_SPACE override the
+// space in octopipe.yaml, without any requests go to the default space
+func selectSpace(name string) {
+	if spaceName != "" {
+		name = spaceName
+	}
+	if name == "" {
+		return
+	}
+
+	ss := octopusSpaces{}
+	getOctopusData(&ss, uri+"/api/spaces/all")
+
+	s, err := getSpace(ss, name, "")
+	if err != nil {
+		logAndExitf(err.Error())
+	}
+	spaceID = s.ID
+}
+
+// apiURL returns the address of an Octopus resource in the selected space
+func apiURL(path string) string {
+	if spaceID == "" {
+		return uri + "/api/" + path
+	}
+	return uri + "/api/" + spaceID + "/" + path
+}
+
+func verifyTriggerEventGroup(t trigger, group string) (eg string, err error) {
+	for _, validType := range validTriggerEventGroups {
+		if validType == group {
+			return group, nil
+		}
+	}
+
+	var errorstring string
+	for _, veg := range validTriggerEventGroups {
+		errorstring = errorstring + veg + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", errors.New("Event '" + group + "' for trigger '" + t.Name + "' is not valid. Valid events are " + errorstring)
+}
+
+func verifyDayOfWeek(t trigger, day string) (d string, err error) {
+	for _, validDay := range validDaysOfWeek {
+		if validDay == day {
+			return day, nil
+		}
+	}
+
+	var errorstring string
+	for _, vd := range validDaysOfWeek {
+		errorstring = errorstring + vd + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", errors.New("Day '" + day + "' for trigger '" + t.Name + "' is not valid. Valid days are " + errorstring)
+}
+
+func getTrigger(ts []octopusProjectTrigger, name string, ID string) (t octopusProjectTrigger, err error) {
+	for _, t := range ts {
+		if t.Name == name || t.ID == ID {
+			return t, nil
+		}
+	}
+	return octopusProjectTrigger{}, errors.New("Trigger with name " + name + " not found")
+}
+
+func getStep(ss []octopusDeploymentStep, name string) (s octopusDeploymentStep, err error) {
+	for _, s := range ss {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return octopusDeploymentStep{}, errors.New("Step with name " + name + " not found")
+}
+
+func getRunbook(rbs []octopusRunbook, name string, ID string) (rb octopusRunbook, err error) {
+	for _, rb := range rbs {
+		if rb.Name == name || rb.ID == ID {
+			return rb, nil
+		}
+	}
+	return octopusRunbook{}, errors.New("Runbook with name " + name + " not found")
+}
+
+func getTenant(ts []octopusTenant, name string, ID string) (t octopusTenant, err error) {
+	for _, t := range ts {
+		if t.Name == name || t.ID == ID {
+			return t, nil
+		}
+	}
+	return octopusTenant{}, errors.New("Tenant with name " + name + " not found")
+}
+
+func getTemplate(ts []octopusTemplate, name string, ID string) (t octopusTemplate, err error) {
+	for _, t := range ts {
+		if t.Name == name || t.ID == ID {
+			return t, nil
+		}
+	}
+	return octopusTemplate{}, errors.New("Template with name " + name + " not found")
+}
+
+func verifyControlType(t template) (ct string, err error) {
+	for _, validType := range validControlTypes {
+		if validType == t.ControlType {
+			return t.ControlType, nil
+		}
+	}
+
+	var errorstring string
+	for _, vct := range validControlTypes {
+		errorstring = errorstring + vct + ", "
+	}
+	errorstring = strings.TrimSuffix(errorstring, ", ")
+	return "", errors.New("Control type '" + t.ControlType + "' for template '" + t.Name + "' is not valid. Control types are case sensitive. Valid types are " + errorstring)
+}
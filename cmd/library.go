@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const libraryVariableSetContentType = "Variables"
+
+// getLibraryVariableSetOfType finds a library variable set by name, only matching sets of the content type
+func getLibraryVariableSetOfType(lvs []octopusLibraryVariableSet, name string, contentType string) (lv octopusLibraryVariableSet, err error) {
+	sets := make([]octopusLibraryVariableSet, 0)
+	for _, lv := range lvs {
+		if lv.ContentType == contentType {
+			sets = append(sets, lv)
+		}
+	}
+
+	return getLibraryVariableSet(sets, name, "")
+}
+
+// verifyIncludes checks every library variable set a project includes exists in Octopus or octopipe.yaml
+func (op *octopipe) verifyIncludes(lvs octopusLibraryVariableSets) {
+	for _, name := range op.Project.Include {
+		if _, err := getLibraryVariableSet(lvs, name, ""); err == nil {
+			continue
+		}
+		found := false
+		for _, sv := range op.LibraryVariableSets {
+			found = found || sv.Name == name
+		}
+		for _, m := range op.ScriptModules {
+			found = found || m.Name == name
+		}
+		if !found {
+			logAndExitf("Library variable set with name %s not found", name)
+		}
+	}
+}
+
+// includedNames returns the sorted names of the library variable sets with the Ids given
+func includedNames(included []string, lvs octopusLibraryVariableSets) (names []string) {
+	names = make([]string, 0)
+	for _, ID := range included {
+		if lv, err := getLibraryVariableSet(lvs, "", ID); err == nil {
+			names = append(names, lv.Name)
+		} else {
+			names = append(names, ID)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// desiredIncludedNames returns the library variable sets a project will include after put. Without an
+// include list in octopipe.yaml the sets already included are kept, script modules are always included
+func (op *octopipe) desiredIncludedNames(included []string, lvs octopusLibraryVariableSets) (names []string) {
+	names = op.Project.Include
+	if names == nil {
+		names = includedNames(included, lvs)
+	}
+
+	desired := append([]string{}, names...)
+	for _, m := range op.ScriptModules {
+		if !containsString(desired, m.Name) {
+			desired = append(desired, m.Name)
+		}
+	}
+	sort.Strings(desired)
+
+	return desired
+}
+
+// includedLibraryVariableSets resolves the include list in octopipe.yaml to Ids, keeping the sets
+// already included if there is no list, and adds the script modules
+func (op *octopipe) includedLibraryVariableSets(included []string, moduleIDs []string, lvs octopusLibraryVariableSets) []string {
+	if op.Project.Include != nil {
+		included = make([]string, 0)
+		for _, name := range op.Project.Include {
+			lv, err := getLibraryVariableSet(lvs, name, "")
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			included = append(included, lv.ID)
+		}
+	}
+
+	return linkScriptModules(included, moduleIDs)
+}
+
+func planIncludes(project string, current []string, desired []string) (changes []planChange) {
+	changes = planField("Project", project, "Include", strings.Join(current, ", "), strings.Join(desired, ", "))
+	return withResourceAction(changes, "change")
+}
+
+// planLibraryVariableSets compares the library variable sets in octopipe.yaml with Octopus. Variables
+// are planned as LibraryVariable changes identified by the set name and variable key
+func planLibraryVariableSets(sets []libraryVariableSet, lvs octopusLibraryVariableSets, prune bool) (changes []planChange) {
+	for _, sv := range sets {
+		desired := flattenVariables(sv.Variables)
+
+		lv, err := getLibraryVariableSetOfType(lvs, sv.Name, libraryVariableSetContentType)
+		if err != nil {
+			added := planField("LibraryVariableSet", sv.Name, "Description", "", sv.Description)
+			if len(added) == 0 {
+				added = []planChange{{Resource: "LibraryVariableSet", ID: sv.Name, Action: "add"}}
+			}
+			changes = append(changes, withResourceAction(added, "add")...)
+			changes = append(changes, libraryVariableChanges(sv.Name, planVariables(nil, desired))...)
+			continue
+		}
+
+		v := octopusVariableSet{}
+		getOctopusData(&v, apiURL("variables/"+lv.VariableSetID))
+
+		changed := planField("LibraryVariableSet", sv.Name, "Description", lv.Description, sv.Description)
+		changes = append(changes, withResourceAction(changed, "change")...)
+		changes = append(changes, libraryVariableChanges(sv.Name, planVariables(v.namedVariables(), plannedVariables(v.namedVariables(), desired, prune)))...)
+	}
+
+	return changes
+}
+
+func libraryVariableChanges(set string, changes []planChange) []planChange {
+	for i := range changes {
+		changes[i].Resource = "LibraryVariable"
+		changes[i].ID = set + ": " + changes[i].ID
+	}
+
+	return changes
+}
+
+// putLibraryVariableSets creates or updates the library variable sets in octopipe.yaml and their
+// variables. New sets are added to lvs
+func putLibraryVariableSets(sets []libraryVariableSet, lvs *octopusLibraryVariableSets, prune bool) {
+	for _, sv := range sets {
+		lv, err := getLibraryVariableSetOfType(*lvs, sv.Name, libraryVariableSetContentType)
+		if err != nil {
+			lv = octopusLibraryVariableSet{
+				Name:        sv.Name,
+				Description: sv.Description,
+				ContentType: libraryVariableSetContentType,
+			}
+			postOctopusData(&lv, apiURL("libraryvariablesets"))
+			*lvs = append(*lvs, lv)
+		} else if lv.Description != sv.Description {
+			lv.Description = sv.Description
+			putOctopusData(&lv, apiURL("libraryvariablesets/"+lv.ID))
+		}
+
+		v := octopusVariableSet{}
+		getOctopusData(&v, apiURL("variables/"+lv.VariableSetID))
+		v.Variables = ownedVariables(&v, v.keepSensitiveValues(v.resolveVariableScopes(flattenVariables(sv.Variables))), prune, " from library variable set "+sv.Name)
+		putOctopusData(v, apiURL("variables/"+lv.VariableSetID))
+		fmt.Println("Put Library Variable Set " + sv.Name)
+	}
+}
+
+// exportIncludes returns the names of the library variable sets a project includes, script modules
+// are left out as they are exported with their content
+func exportIncludes(included []string, lvs octopusLibraryVariableSets) (names []string) {
+	for _, ID := range included {
+		lv, err := getLibraryVariableSet(lvs, "", ID)
+		if err != nil || lv.ContentType == scriptModuleContentType {
+			continue
+		}
+		names = append(names, lv.Name)
+	}
+
+	return names
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Octopus stores the content and syntax of a script module as variables in its library variable set
+const (
+	scriptModuleContentType     = "ScriptModule"
+	scriptModuleContentVariable = "Octopus.Script.Module["
+	scriptModuleSyntaxVariable  = "Octopus.Script.Module.Language["
+)
+
+// buildScriptModules reads the file of every script module in octopipe.yaml, keyed by module name.
+// This happens before anything is put so a missing file doesn't leave Octopus half updated
+func buildScriptModules(modules []scriptModule) (vars map[string][]octopusVariable) {
+	vars = make(map[string][]octopusVariable)
+
+	for _, m := range modules {
+		syntax, err := verifyScriptModuleSyntaxType(m)
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		if _, ok := vars[m.Name]; ok {
+			logAndExitf("Script module '%s' is defined more than once", m.Name)
+		}
+
+		contents, err := ioutil.ReadFile(m.File)
+		if err != nil {
+			logAndExitf("Error opening %s:\n%s", m.File, err.Error())
+		}
+
+		vars[m.Name] = []octopusVariable{
+			{Name: scriptModuleContentVariable + m.Name + "]", Value: string(contents), Type: "String"},
+			{Name: scriptModuleSyntaxVariable + m.Name + "]", Value: syntax, Type: "String"},
+		}
+	}
+
+	return vars
+}
+
+// scriptModuleValues returns the content and syntax of a script module from its variables
+func scriptModuleValues(vars []octopusVariable, name string) (content string, syntax string) {
+	for _, v := range vars {
+		switch v.Name {
+		case scriptModuleContentVariable + name + "]":
+			content = v.Value
+		case scriptModuleSyntaxVariable + name + "]":
+			syntax = v.Value
+		}
+	}
+
+	return content, syntax
+}
+
+func planScriptModules(modules []scriptModule, vars map[string][]octopusVariable, lvs octopusLibraryVariableSets) (changes []planChange) {
+	for _, m := range modules {
+		content, syntax := scriptModuleValues(vars[m.Name], m.Name)
+
+		lv, err := getLibraryVariableSetOfType(lvs, m.Name, scriptModuleContentType)
+		if err != nil {
+			added := make([]planChange, 0)
+			added = append(added, planField("ScriptModule", m.Name, "Description", "", m.Description)...)
+			added = append(added, planField("ScriptModule", m.Name, "Syntax", "", syntax)...)
+			added = append(added, planField("ScriptModule", m.Name, "Content", "", content)...)
+			changes = append(changes, withResourceAction(added, "add")...)
+			continue
+		}
+
+		v := octopusVariableSet{}
+		getOctopusData(&v, apiURL("variables/"+lv.VariableSetID))
+		currentContent, currentSyntax := scriptModuleValues(v.Variables, m.Name)
+
+		changed := make([]planChange, 0)
+		changed = append(changed, planField("ScriptModule", m.Name, "Description", lv.Description, m.Description)...)
+		changed = append(changed, planField("ScriptModule", m.Name, "Syntax", currentSyntax, syntax)...)
+		changed = append(changed, planField("ScriptModule", m.Name, "Content", currentContent, content)...)
+		changes = append(changes, withResourceAction(changed, "change")...)
+	}
+
+	return changes
+}
+
+// putScriptModules creates or updates the script modules in octopipe.yaml and returns their Ids.
+// New modules are added to lvs
+func putScriptModules(modules []scriptModule, vars map[string][]octopusVariable, lvs *octopusLibraryVariableSets) (IDs []string) {
+	for _, m := range modules {
+		lv, err := getLibraryVariableSetOfType(*lvs, m.Name, scriptModuleContentType)
+		if err != nil {
+			lv = octopusLibraryVariableSet{
+				Name:        m.Name,
+				Description: m.Description,
+				ContentType: scriptModuleContentType,
+			}
+			postOctopusData(&lv, apiURL("libraryvariablesets"))
+			*lvs = append(*lvs, lv)
+		} else if lv.Description != m.Description {
+			lv.Description = m.Description
+			putOctopusData(&lv, apiURL("libraryvariablesets/"+lv.ID))
+		}
+
+		v := octopusVariableSet{}
+		getOctopusData(&v, apiURL("variables/"+lv.VariableSetID))
+		v.Variables = vars[m.Name]
+		putOctopusData(v, apiURL("variables/"+lv.VariableSetID))
+		fmt.Println("Put Script Module " + m.Name)
+
+		IDs = append(IDs, lv.ID)
+	}
+
+	return IDs
+}
+
+// linkScriptModules adds any script module Ids a project doesn't already include
+func linkScriptModules(included []string, IDs []string) []string {
+	if included == nil {
+		included = make([]string, 0)
+	}
+	for _, ID := range IDs {
+		if !containsString(included, ID) {
+			included = append(included, ID)
+		}
+	}
+
+	return included
+}
+
+// exportScriptModules writes the script modules a project includes to the scriptmodules folder
+func exportScriptModules(base string, included []string, lvs octopusLibraryVariableSets) (modules []scriptModule) {
+	for _, ID := range included {
+		lv, err := getLibraryVariableSet(lvs, "", ID)
+		if err != nil || lv.ContentType != scriptModuleContentType {
+			continue
+		}
+
+		v := octopusVariableSet{}
+		getOctopusData(&v, apiURL("variables/"+lv.VariableSetID))
+		content, syntax := scriptModuleValues(v.Variables, lv.Name)
+
+		err = os.MkdirAll(filepath.Join(base, "scriptmodules"), 0755)
+		if err != nil {
+			logAndExitf("Failed to create scriptmodules:\n%s", err.Error())
+		}
+
+		m := scriptModule{
+			Name:        lv.Name,
+			Description: lv.Description,
+			Syntax:      syntax,
+			File:        "scriptmodules/" + getProjectSlug(lv.Name) + "." + scriptExtensions[syntax],
+		}
+
+		err = ioutil.WriteFile(filepath.Join(base, m.File), []byte(content), 0644)
+		if err != nil {
+			logAndExitf("Failed to write script module to disk:\n%s\n", err.Error())
+		}
+
+		modules = append(modules, m)
+	}
+
+	return modules
+}
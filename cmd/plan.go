@@ -0,0 +1,540 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	colourReset  = "\033[0m"
+	colourRed    = "\033[31m"
+	colourGreen  = "\033[32m"
+	colourYellow = "\033[33m"
+)
+
+// planChange is a single field that put would add, change or remove on an Octopus resource
+type planChange struct {
+	Resource       string              `json:"resourceType"`
+	ID             string              `json:"id"`
+	Field          string              `json:"field"`
+	Old            string              `json:"oldValue"`
+	New            string              `json:"newValue"`
+	Action         string              `json:"action"`
+	ResourceAction string              `json:"resourceAction"`
+	Scope          map[string][]string `json:"scope,omitempty"`
+}
+
+// planFormatVersion is the version of the plan document, raised whenever its fields change.
+// Version 2 added resourceAction to each change
+const planFormatVersion = 2
+
+// planDocument is the machine readable plan written by put --plan-output
+type planDocument struct {
+	FormatVersion int            `json:"formatVersion"`
+	Project       string         `json:"project"`
+	Versions      map[string]int `json:"versions"`
+	Changes       []planChange   `json:"changes"`
+}
+
+func planField(resource string, ID string, field string, old string, new string) (changes []planChange) {
+	if old == new {
+		return nil
+	}
+
+	action := "change"
+	if old == "" {
+		action = "add"
+	} else if new == "" {
+		action = "remove"
+	}
+
+	return []planChange{{Resource: resource, ID: ID, Field: field, Old: old, New: new, Action: action}}
+}
+
+func withResourceAction(changes []planChange, action string) []planChange {
+	for i := range changes {
+		changes[i].ResourceAction = action
+	}
+
+	return changes
+}
+
+func planProject(current project, desired project) (changes []planChange) {
+	changes = append(changes, planField("Project", desired.Name, "Name", current.Name, desired.Name)...)
+	changes = append(changes, planField("Project", desired.Name, "Description", current.Description, desired.Description)...)
+	changes = append(changes, planField("Project", desired.Name, "Lifecycle", current.Lifecycle, desired.Lifecycle)...)
+	changes = append(changes, planField("Project", desired.Name, "ProjectGroup", current.ProjectGroup, desired.ProjectGroup)...)
+	changes = append(changes, planField("Project", desired.Name, "Tenanted", current.Tenanted, desired.Tenanted)...)
+
+	return withResourceAction(changes, "change")
+}
+
+// channelNames returns the names of channels for plans, channels that aren't put yet have their name as Id
+func (r *processResources) channelNames(IDs []string) []string {
+	names := make([]string, 0)
+	for _, ID := range IDs {
+		if c, err := getChannel(r.channels.Items, "", ID); err == nil {
+			ID = c.Name
+		}
+		names = append(names, ID)
+	}
+
+	return names
+}
+
+// workerPoolName returns the name of a worker pool for plans, falling back to the Id
+func (r *processResources) workerPoolName(ID string) string {
+	if wp, err := getWorkerPool(r.workerPools, "", ID); ID != "" && err == nil {
+		return wp.Name
+	}
+	return ID
+}
+
+// planFlag returns a boolean field for plans, false is shown as no value
+func planFlag(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+// actionContainer returns the container image an action runs in, for plans
+func actionContainer(a octopusDeploymentAction) string {
+	if a.Container == nil {
+		return ""
+	}
+	return a.Container.Image
+}
+
+// packageRequirement returns the package requirement of a step for plans, the default is shown as no value
+func packageRequirement(s octopusDeploymentStep) string {
+	if s.PackageRequirement == "LetOctopusDecide" {
+		return ""
+	}
+	return s.PackageRequirement
+}
+
+func (r *processResources) planDeploymentAction(ID string, current octopusDeploymentAction, desired octopusDeploymentAction) (changes []planChange) {
+	prefix := ""
+	if ID != desired.Name {
+		prefix = desired.Name + "."
+	}
+
+	changes = append(changes, planField("Step", ID, prefix+"ActionType", current.ActionType, desired.ActionType)...)
+	changes = append(changes, planField("Step", ID, prefix+"WorkerPool", r.workerPoolName(current.WorkerPoolID), r.workerPoolName(desired.WorkerPoolID))...)
+	changes = append(changes, planField("Step", ID, prefix+"Environments", strings.Join(r.environmentNames(current.Environments), ","), strings.Join(r.environmentNames(desired.Environments), ","))...)
+	changes = append(changes, planField("Step", ID, prefix+"ExcludedEnvironments", strings.Join(r.environmentNames(current.ExcludedEnvironments), ","), strings.Join(r.environmentNames(desired.ExcludedEnvironments), ","))...)
+	changes = append(changes, planField("Step", ID, prefix+"Channels", strings.Join(r.channelNames(current.Channels), ","), strings.Join(r.channelNames(desired.Channels), ","))...)
+	changes = append(changes, planField("Step", ID, prefix+"TenantTags", strings.Join(current.TenantTags, ","), strings.Join(desired.TenantTags, ","))...)
+
+	changes = append(changes, planField("Step", ID, prefix+"IsDisabled", planFlag(current.IsDisabled), planFlag(desired.IsDisabled))...)
+	changes = append(changes, planField("Step", ID, prefix+"IsRequired", planFlag(current.IsRequired), planFlag(desired.IsRequired))...)
+	changes = append(changes, planField("Step", ID, prefix+"Notes", current.Notes, desired.Notes)...)
+	changes = append(changes, planField("Step", ID, prefix+"Container", actionContainer(current), actionContainer(desired))...)
+	changes = append(changes, planField("Step", ID, prefix+"WorkerPoolVariable", current.WorkerPoolVariable, desired.WorkerPoolVariable)...)
+
+	// Sensitive properties are only known by name, put replaces them with the property in octopipe.yaml if any
+	for _, k := range current.sensitiveProperties {
+		if !containsString(desired.sensitiveProperties, k) {
+			changes = append(changes, planField("Step", ID, prefix+k, "<sensitive>", desired.Properties[k])...)
+		}
+	}
+	for _, k := range sortedKeys(current.Properties, desired.Properties) {
+		if !containsString(current.sensitiveProperties, k) {
+			changes = append(changes, planField("Step", ID, prefix+k, current.Properties[k], desired.Properties[k])...)
+		}
+	}
+
+	cp := make(map[string]string)
+	for _, pr := range current.Packages {
+		cp[pr.Name] = pr.PackageID + " from " + pr.FeedID
+	}
+	dp := make(map[string]string)
+	for _, pr := range desired.Packages {
+		dp[pr.Name] = pr.PackageID + " from " + pr.FeedID
+	}
+	for _, k := range sortedKeys(cp, dp) {
+		changes = append(changes, planField("Step", ID, prefix+"Packages["+k+"]", cp[k], dp[k])...)
+	}
+
+	return changes
+}
+
+func (r *processResources) planDeploymentProcess(current []octopusDeploymentStep, desired []octopusDeploymentStep) (changes []planChange) {
+	for _, ds := range desired {
+		cs := octopusDeploymentStep{}
+		for _, s := range current {
+			if s.Name == ds.Name {
+				cs = s
+				break
+			}
+		}
+
+		stepChanges := make([]planChange, 0)
+		stepChanges = append(stepChanges, planField("Step", ds.Name, "Condition", cs.Condition, ds.Condition)...)
+		stepChanges = append(stepChanges, planField("Step", ds.Name, "StartTrigger", cs.StartTrigger, ds.StartTrigger)...)
+		stepChanges = append(stepChanges, planField("Step", ds.Name, "PackageRequirement", packageRequirement(cs), packageRequirement(ds))...)
+		for _, k := range sortedKeys(cs.Properties, ds.Properties) {
+			stepChanges = append(stepChanges, planField("Step", ds.Name, k, cs.Properties[k], ds.Properties[k])...)
+		}
+		for _, da := range ds.Actions {
+			ca := octopusDeploymentAction{}
+			for _, a := range cs.Actions {
+				if a.Name == da.Name {
+					ca = a
+					break
+				}
+			}
+			stepChanges = append(stepChanges, r.planDeploymentAction(ds.Name, ca, da)...)
+		}
+		for _, ca := range cs.Actions {
+			found := false
+			for _, da := range ds.Actions {
+				if da.Name == ca.Name {
+					found = true
+				}
+			}
+			if !found {
+				stepChanges = append(stepChanges, r.planDeploymentAction(ds.Name, ca, octopusDeploymentAction{Name: ca.Name})...)
+			}
+		}
+
+		if cs.Name == "" {
+			changes = append(changes, withResourceAction(stepChanges, "add")...)
+		} else {
+			changes = append(changes, withResourceAction(stepChanges, "change")...)
+		}
+	}
+
+	for _, cs := range current {
+		found := false
+		for _, ds := range desired {
+			if ds.Name == cs.Name {
+				found = true
+			}
+		}
+		if !found {
+			for _, k := range sortedKeys(cs.Properties) {
+				changes = append(changes, withResourceAction(planField("Step", cs.Name, k, cs.Properties[k], ""), "remove")...)
+			}
+			for _, ca := range cs.Actions {
+				changes = append(changes, withResourceAction(r.planDeploymentAction(cs.Name, ca, octopusDeploymentAction{Name: ca.Name}), "remove")...)
+			}
+		}
+	}
+
+	return changes
+}
+
+// variableKey identifies a variable value by its name and scope, scope values must be names
+func variableKey(v octopusVariable) (key string) {
+	scopes := make([]string, 0)
+	for stype, names := range v.Scope {
+		sorted := append([]string{}, names...)
+		sort.Strings(sorted)
+		scopes = append(scopes, stype+"="+strings.Join(sorted, ","))
+	}
+	sort.Strings(scopes)
+
+	if len(scopes) == 0 {
+		return v.Name
+	}
+	return v.Name + " [" + strings.Join(scopes, "; ") + "]"
+}
+
+// sensitiveValue masks the value of a sensitive variable for display
+func sensitiveValue(v octopusVariable, kept bool) string {
+	if !v.IsSensitive {
+		return v.Value
+	} else if v.Value != "" {
+		return "<new sensitive value>"
+	} else if kept {
+		return "<sensitive>"
+	}
+
+	return "<sensitive, not set>"
+}
+
+// describePrompt describes the prompt of a variable, for plans
+func describePrompt(p *octopusVariablePrompt) string {
+	if p == nil {
+		return ""
+	}
+
+	d := p.Label
+	if p.Description != "" {
+		d = d + " - " + p.Description
+	}
+	if p.Required {
+		d = d + " (required)"
+	}
+
+	return d
+}
+
+func planVariable(key string, current octopusVariable, desired octopusVariable) (changes []planChange) {
+	old := sensitiveValue(current, true)
+	new := sensitiveValue(desired, current.IsSensitive)
+
+	if current.Name == "" {
+		changes = append(changes, planChange{Resource: "Variable", ID: key, Field: "Value", New: new, Action: "add"})
+	} else if desired.Name == "" {
+		changes = append(changes, planChange{Resource: "Variable", ID: key, Field: "Value", Old: old, Action: "remove"})
+	} else if old != new {
+		changes = append(changes, planChange{Resource: "Variable", ID: key, Field: "Value", Old: old, New: new, Action: "change"})
+	}
+
+	changes = append(changes, planField("Variable", key, "Type", current.Type, desired.Type)...)
+	changes = append(changes, planField("Variable", key, "Description", current.Description, desired.Description)...)
+	changes = append(changes, planField("Variable", key, "Prompt", describePrompt(current.Prompt), describePrompt(desired.Prompt))...)
+
+	scope := current.Scope
+	if desired.Name != "" {
+		scope = desired.Scope
+	}
+	for i := range changes {
+		changes[i].Scope = scope
+	}
+
+	if current.Name == "" {
+		return withResourceAction(changes, "add")
+	} else if desired.Name == "" {
+		return withResourceAction(changes, "remove")
+	}
+	return withResourceAction(changes, "change")
+}
+
+// planVariables compares variables by name and scope, both sets must have their scopes as names
+func planVariables(current []octopusVariable, desired []octopusVariable) (changes []planChange) {
+	cm := make(map[string]octopusVariable)
+	for _, v := range current {
+		cm[variableKey(v)] = v
+	}
+
+	dm := make(map[string]octopusVariable)
+	for _, v := range desired {
+		key := variableKey(v)
+		dm[key] = v
+		changes = append(changes, planVariable(key, cm[key], v)...)
+	}
+
+	for _, v := range current {
+		key := variableKey(v)
+		if _, ok := dm[key]; !ok {
+			changes = append(changes, planVariable(key, v, octopusVariable{})...)
+		}
+	}
+
+	return changes
+}
+
+func sortedKeys(maps ...map[string]string) (keys []string) {
+	seen := make(map[string]bool)
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// diffLines returns a line by line diff of two multi-line values, prefixing lines with +, - or a space
+func diffLines(old string, new string) (lines []string) {
+	a := make([]string, 0)
+	if old != "" {
+		a = strings.Split(old, "\n")
+	}
+	b := make([]string, 0)
+	if new != "" {
+		b = strings.Split(new, "\n")
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		} else if i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, "- "+a[i])
+			i++
+		} else {
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+
+	return lines
+}
+
+func useColour() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func colourLine(line string, colour string) string {
+	if !useColour() {
+		return line
+	}
+
+	return colour + line + colourReset
+}
+
+func colourDiffLine(line string) string {
+	if strings.HasPrefix(line, "+") {
+		return colourLine(line, colourGreen)
+	} else if strings.HasPrefix(line, "-") {
+		return colourLine(line, colourRed)
+	}
+
+	return line
+}
+
+// printPlan prints the changes grouped by resource, in the order they were planned
+func printPlan(changes []planChange) {
+	if len(changes) == 0 {
+		fmt.Println("No changes. Octopus matches octopipe.yaml")
+		return
+	}
+
+	groups := make([]string, 0)
+	grouped := make(map[string][]planChange)
+	for _, c := range changes {
+		key := c.Resource + " " + c.ID
+		if _, ok := grouped[key]; !ok {
+			groups = append(groups, key)
+		}
+		grouped[key] = append(grouped[key], c)
+	}
+
+	adds, updates, removes := 0, 0, 0
+	for _, key := range groups {
+		switch grouped[key][0].ResourceAction {
+		case "add":
+			adds++
+			fmt.Println(colourLine("+ "+key, colourGreen))
+		case "remove":
+			removes++
+			fmt.Println(colourLine("- "+key, colourRed))
+		default:
+			updates++
+			fmt.Println(colourLine("~ "+key, colourYellow))
+		}
+
+		for _, c := range grouped[key] {
+			if c.Field == "" {
+				continue
+			}
+			if strings.Contains(c.Old, "\n") || strings.Contains(c.New, "\n") {
+				fmt.Printf("    %s:\n", c.Field)
+				for _, line := range diffLines(c.Old, c.New) {
+					fmt.Println("      " + colourDiffLine(line))
+				}
+				continue
+			}
+			switch c.Action {
+			case "add":
+				fmt.Println(colourLine(fmt.Sprintf("    + %s: %q", c.Field, c.New), colourGreen))
+			case "remove":
+				fmt.Println(colourLine(fmt.Sprintf("    - %s: %q", c.Field, c.Old), colourRed))
+			default:
+				fmt.Println(colourLine(fmt.Sprintf("    ~ %s: %q => %q", c.Field, c.Old, c.New), colourYellow))
+			}
+		}
+	}
+
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to remove\n", adds, updates, removes)
+}
+
+// writePlan writes the changes as a json plan document to a file, or to stdout if the file name is -
+func writePlan(changes []planChange, projectName string, versions map[string]int, filename string) {
+	doc := planDocument{
+		FormatVersion: planFormatVersion,
+		Project:       projectName,
+		Versions:      versions,
+		Changes:       changes,
+	}
+
+	contents, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		logAndExitf("Failed to serialize plan:\n%s", err.Error())
+	}
+
+	if filename == "-" {
+		fmt.Println(string(contents))
+		return
+	}
+
+	err = ioutil.WriteFile(filename, contents, 0644)
+	if err != nil {
+		logAndExitf("Failed to write plan to disk:\n%s", err.Error())
+	}
+}
+
+// verifyPlanVersions aborts if a plan file is for another project or the versions recorded in it no
+// longer match those in Octopus
+func verifyPlanVersions(filename string, projectName string, versions map[string]int) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		logAndExitf("Error opening %s:\n%s", filename, err.Error())
+	}
+
+	doc := planDocument{}
+	err = json.Unmarshal(contents, &doc)
+	if err != nil {
+		logAndExitf("Error importing plan %s:\n%s", filename, err.Error())
+	}
+	if doc.Project != projectName {
+		logAndExitf("%s is a plan for project '%s', not '%s'", filename, doc.Project, projectName)
+	}
+
+	for resource, version := range doc.Versions {
+		if versions[resource] != version {
+			logAndExitf("Conflict: %s has changed in Octopus since %s was written (version %d, now %d). Run put --plan again to review the changes", resource, filename, version, versions[resource])
+		}
+	}
+}
+
+// verifyVersion aborts if a resource has been modified in Octopus since it was read at the given version
+func verifyVersion(resource string, uri string, version int) {
+	current := struct {
+		Version int `json:"Version"`
+	}{}
+	getOctopusData(&current, uri)
+
+	if current.Version != version {
+		logAndExitf("Conflict: %s was modified in Octopus while put was running (version %d, now %d). Run put --plan again to review the changes", resource, version, current.Version)
+	}
+}
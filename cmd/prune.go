@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// mergeSteps returns the steps in octopipe.yaml with the steps in Octopus that it doesn't define,
+// each kept after the step it follows in Octopus
+func mergeSteps(current []octopusDeploymentStep, desired []octopusDeploymentStep) (merged []octopusDeploymentStep) {
+	merged = append([]octopusDeploymentStep{}, desired...)
+
+	index := func(name string) int {
+		for i, s := range merged {
+			if s.Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	previous := ""
+	for _, cs := range current {
+		if _, err := getStep(desired, cs.Name); err != nil {
+			i := 0
+			if previous != "" {
+				i = index(previous) + 1
+			}
+			merged = append(merged[:i], append([]octopusDeploymentStep{cs}, merged[i:]...)...)
+		}
+		previous = cs.Name
+	}
+
+	return merged
+}
+
+// unmanagedVariables returns the variables in Octopus with a name that isn't in octopipe.yaml
+func unmanagedVariables(current []octopusVariable, desired []octopusVariable) (unmanaged []octopusVariable) {
+	names := make(map[string]bool)
+	for _, v := range desired {
+		names[v.Name] = true
+	}
+
+	for _, v := range current {
+		if !names[v.Name] {
+			unmanaged = append(unmanaged, v)
+		}
+	}
+
+	return unmanaged
+}
+
+// plannedSteps returns the steps put writes. Steps not in octopipe.yaml are kept unless pruning
+func plannedSteps(current []octopusDeploymentStep, desired []octopusDeploymentStep, prune bool) []octopusDeploymentStep {
+	if prune {
+		return desired
+	}
+
+	return mergeSteps(current, desired)
+}
+
+// plannedVariables returns the variables put writes. Variables with a name not in octopipe.yaml are
+// kept unless pruning
+func plannedVariables(current []octopusVariable, desired []octopusVariable, prune bool) []octopusVariable {
+	if prune {
+		return desired
+	}
+
+	return append(desired, unmanagedVariables(current, desired)...)
+}
+
+// ownedSteps returns the steps put writes, listing each step it removes first
+func ownedSteps(current []octopusDeploymentStep, desired []octopusDeploymentStep, prune bool, from string) []octopusDeploymentStep {
+	if prune {
+		for _, cs := range current {
+			if _, err := getStep(desired, cs.Name); err != nil {
+				fmt.Println("Removing Step " + cs.Name + from)
+			}
+		}
+	}
+
+	return plannedSteps(current, desired, prune)
+}
+
+// ownedVariables returns the variables put writes, listing each variable it removes first. Desired
+// variables must have their scopes resolved to Ids
+func ownedVariables(v *octopusVariableSet, desired []octopusVariable, prune bool, from string) []octopusVariable {
+	if prune {
+		for _, rv := range unmanagedVariables(v.namedVariables(), desired) {
+			fmt.Println("Removing Variable " + variableKey(rv) + from)
+		}
+	}
+
+	return plannedVariables(v.Variables, desired, prune)
+}
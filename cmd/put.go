@@ -17,7 +17,7 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -31,9 +31,38 @@ var putCmd = &cobra.Command{
 Use the put command to write the local
 project configuration data into Octopus
 
+Pass --plan to fetch the current project, deployment process
+and variables from Octopus and print what would be added,
+changed or removed without writing anything. Use --plan-output
+to also write the changes as json for use in CI
+
+Variable values can refer to secrets stored outside octopipe.yaml
+with env:NAME, file:path, vault:path#key or
+keyvault://vault/secret[/version], these are resolved
+before anything is written and put as sensitive variables
+
+Put aborts without writing if the deployment process or variables
+are modified in Octopus while it runs. Pass --lock with a file
+written by --plan-output to also abort if they have changed
+since that plan was made
+
+Steps and variables in Octopus that are not in octopipe.yaml
+are kept unless --prune is passed, which lists and removes them.
+This applies to the deployment process, project variables, the
+steps of runbooks and the variables of library variable sets
+
+Pass --publish-runbooks to publish a snapshot of each runbook
+after it is put, so the new process is the one that runs
+
 Usage:
 
 octopipe put
+octopipe put --plan
+octopipe put --plan-output plan.json
+octopipe put --plan-output -
+octopipe put --lock plan.json
+octopipe put --publish-runbooks
+octopipe put --prune --plan
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -41,19 +70,37 @@ octopipe put
 		// Start
 		start := time.Now()
 
+		plan, _ := cmd.Flags().GetBool("plan")
+		po, _ := cmd.Flags().GetString("plan-output")
+		lf, _ := cmd.Flags().GetString("lock")
+		publish, _ := cmd.Flags().GetBool("publish-runbooks")
+		merge, _ := cmd.Flags().GetBool("merge")
+		prune, _ := cmd.Flags().GetBool("prune")
+		if prune && cmd.Flags().Changed("merge") {
+			logAndExitf("Use either --merge or --prune, not both")
+		}
+		if !merge && !prune {
+			logAndExitf("Steps and variables not in octopipe.yaml are only removed with --prune, pass --prune instead of --merge=false")
+		}
+		if po != "" {
+			plan = true
+		}
+
 		if apiKey == "" || uri == "" {
 			logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
 		}
 
 		var op octopipe
 		op.importOctopipeFile()
+		op.resolveSecrets()
+		selectSpace(op.Space)
 
 		//Project
 		l := octopusLifecycles{}
 		g := octopusProjectGroups{}
 
-		getOctopusData(&l, uri+"/api/lifecycles/all")
-		getOctopusData(&g, uri+"/api/projectgroups/all")
+		getOctopusData(&l, apiURL("lifecycles/all"))
+		getOctopusData(&g, apiURL("projectgroups/all"))
 
 		lifecycle, err := getLifecycle(l, op.Project.Lifecycle, "")
 		if err != nil {
@@ -67,7 +114,7 @@ octopipe put
 
 		p := &octopusProject{}
 		slug := getProjectSlug(op.Project.Name)
-		presp, status := doOctopusRequest(nil, uri+"/api/projects/"+slug, "GET")
+		presp, status := doOctopusRequest(nil, apiURL("projects/"+slug), "GET")
 		json.Unmarshal(presp, &p)
 
 		tenancy := "Untenanted"
@@ -78,6 +125,110 @@ octopipe put
 			}
 		}
 
+		r := getProcessResources(p.ID)
+		currentChannels := append([]octopusChannel{}, r.channels.Items...)
+		r.addChannels(op.Project.Channels)
+		news := r.buildDeploymentSteps(op.Process.Steps)
+		channels := r.buildChannels(op.Project.Channels, l, news)
+		triggers := r.buildTriggers(op.Triggers)
+		runbooks := r.buildRunbooks(op.Runbooks)
+
+		// Without a templates list in octopipe.yaml the project keeps the templates it has
+		templates := p.Templates
+		if op.Project.Templates != nil {
+			templates = buildTemplates(op.Project.Templates, p.Templates)
+		}
+
+		lvs := octopusLibraryVariableSets{}
+		getOctopusData(&lvs, apiURL("libraryvariablesets/all"))
+		modules := buildScriptModules(op.ScriptModules)
+		op.verifyIncludes(lvs)
+		r.verifyTenants(op.Tenants, tenancy, templates, lvs)
+
+		// The deployment process and variables are read before anything is written so
+		// their versions can be checked for changes made by someone else before they are put
+		d := octopusDeploymentProcess{}
+		v := octopusVariableSet{}
+		if status != 404 {
+			getOctopusData(&d, apiURL("deploymentprocesses/"+p.DeploymentProcessID))
+			getOctopusData(&v, apiURL("variables/"+p.VariableSetID))
+		}
+
+		versions := map[string]int{
+			"deploymentProcess": d.Version,
+			"variableSet":       v.Version,
+		}
+
+		if lf != "" {
+			verifyPlanVersions(lf, op.Project.Name, versions)
+		}
+
+		if plan {
+			changes := make([]planChange, 0)
+			if status == 404 {
+				changes = append(changes, planChange{Resource: "Project", ID: op.Project.Name, Action: "add", ResourceAction: "add"})
+			} else {
+				currentLifecycle, _ := getLifecycle(l, "", p.LifecycleID)
+				currentProjectGroup, _ := getProjectGroup(g, "", p.ProjectGroupID)
+				current := project{
+					Name:         p.Name,
+					Description:  p.Description,
+					Lifecycle:    currentLifecycle.Name,
+					ProjectGroup: currentProjectGroup.Name,
+					Tenanted:     p.TenantedDeploymentMode,
+				}
+				desired := op.Project
+				desired.Lifecycle = lifecycle.Name
+				desired.ProjectGroup = projectGroup.Name
+				desired.Tenanted = tenancy
+				changes = append(changes, planProject(current, desired)...)
+				changes = append(changes, planIncludes(op.Project.Name, includedNames(p.IncludedLibraryVariableSetIds, lvs), op.desiredIncludedNames(p.IncludedLibraryVariableSetIds, lvs))...)
+			}
+
+			changes = append(changes, planScriptModules(op.ScriptModules, modules, lvs)...)
+			changes = append(changes, planLibraryVariableSets(op.LibraryVariableSets, lvs, prune)...)
+
+			if op.Project.Templates != nil {
+				changes = append(changes, planTemplates(p.Templates, templates)...)
+			}
+
+			changes = append(changes, planChannels(currentChannels, channels, l)...)
+			changes = append(changes, r.planDeploymentProcess(d.Steps, plannedSteps(d.Steps, news, prune))...)
+			changes = append(changes, planVariables(v.namedVariables(), plannedVariables(v.namedVariables(), flattenVariables(op.Variables), prune))...)
+
+			currentRunbooks := octopusRunbooks{}
+			if status != 404 && len(runbooks) != 0 {
+				getOctopusData(&currentRunbooks, apiURL("projects/"+p.ID+"/runbooks"))
+			}
+			changes = append(changes, r.planRunbooks(currentRunbooks.Items, runbooks, prune)...)
+
+			currentTriggers := octopusProjectTriggers{}
+			if status != 404 && len(triggers) != 0 {
+				getOctopusData(&currentTriggers, apiURL("projects/"+p.ID+"/triggers"))
+			}
+			changes = append(changes, r.planTriggers(currentTriggers.Items, triggers)...)
+			changes = append(changes, r.planTenants(p.ID, op.Tenants, templates, lvs)...)
+
+			if po != "-" {
+				printPlan(changes)
+			}
+			if po != "" {
+				writePlan(changes, op.Project.Name, versions, po)
+			}
+			return
+		}
+
+		// Check for changes made in Octopus while the changes were built, before anything is written.
+		// Each put checks again in case someone changes them while put is running
+		if status != 404 {
+			verifyVersion("Deployment process", apiURL("deploymentprocesses/"+p.DeploymentProcessID), d.Version)
+			verifyVersion("Variable set", apiURL("variables/"+p.VariableSetID), v.Version)
+		}
+
+		// Script modules and library variable sets are put first so the project can include them
+		moduleIDs := putScriptModules(op.ScriptModules, modules, &lvs)
+		putLibraryVariableSets(op.LibraryVariableSets, &lvs, prune)
+
 		if status == 404 {
 
 			newp := &octopusProject{
@@ -86,11 +237,19 @@ octopipe put
 				TenantedDeploymentMode: tenancy,
 				LifecycleID:            lifecycle.ID,
 				ProjectGroupID:         projectGroup.ID,
+
+				IncludedLibraryVariableSetIds: op.includedLibraryVariableSets(make([]string, 0), moduleIDs, lvs),
+			}
+			if templates != nil {
+				newp.Templates = templates
 			}
 
-			postOctopusData(newp, uri+"/api/projects")
+			postOctopusData(newp, apiURL("projects"))
 			p = newp
 
+			getOctopusData(&d, apiURL("deploymentprocesses/"+p.DeploymentProcessID))
+			getOctopusData(&v, apiURL("variables/"+p.VariableSetID))
+
 		} else {
 
 			p.Name = op.Project.Name
@@ -98,125 +257,67 @@ octopipe put
 			p.ProjectGroupID = projectGroup.ID
 			p.Description = op.Project.Description
 			p.TenantedDeploymentMode = tenancy
+			p.IncludedLibraryVariableSetIds = op.includedLibraryVariableSets(p.IncludedLibraryVariableSetIds, moduleIDs, lvs)
+			p.Templates = templates
 
-			putOctopusData(p, uri+"/api/projects/"+p.ID)
+			putOctopusFields(p, apiURL("projects/"+p.ID), "Name", "LifecycleId", "ProjectGroupId", "Description", "TenantedDeploymentMode", "IncludedLibraryVariableSetIds", "Templates")
 
 			fmt.Println("Put Project")
 		}
 
-		// Deployment process
-		d := octopusDeploymentProcess{}
-		getOctopusData(&d, uri+"/api/deploymentprocesses/"+p.DeploymentProcessID)
-
-		news := make([]octopusDeploymentStep, 0)
-
-		for _, s := range op.Process.Steps {
-			newa := make([]octopusDeploymentAction, 0)
-
-			taa, err := ioutil.ReadFile(s.File)
-			if err != nil {
-				logAndExitf("Error opening %s:\n%s", s.File, err.Error())
-			}
-
-			tap := make(map[string]string)
-
-			thistype, err := verifySyntaxType(s)
-			if err != nil {
-				logAndExitf(err.Error())
-			}
-
-			tap["Octopus.Action.Script.Syntax"] = thistype
-			tap["Octopus.Action.Script.ScriptSource"] = "Inline"
-			tap["Octopus.Action.Script.ScriptBody"] = string(taa)
-			tap["Octopus.Action.RunOnServer"] = "True"
+		// Channels are put before the deployment process and variables that refer to them. Version
+		// rules for packages of steps that aren't in the process yet are put once the process is
+		var laterChannels []octopusChannel
+		if len(channels) != 0 {
+			var firstChannels []octopusChannel
+			firstChannels, laterChannels = rulesForSteps(channels, d.Steps)
+			putChannels(p.ID, firstChannels)
+		}
 
-			ta := octopusDeploymentAction{
-				Name:         s.Name,
-				ActionType:   "Octopus.Script",
-				WorkerPoolID: "WorkerPools-1",
-				Properties:   tap,
-			}
+		// Steps and triggers are built again once the channels they refer to have Ids
+		if len(channels) != 0 || status == 404 {
+			r.channels = getProjectChannels(p.ID)
+			news = r.buildDeploymentSteps(op.Process.Steps)
+			triggers = r.buildTriggers(op.Triggers)
+		}
 
-			newa = append(newa, ta)
+		// Deployment process
+		verifyVersion("Deployment process", apiURL("deploymentprocesses/"+p.DeploymentProcessID), d.Version)
 
-			ts := octopusDeploymentStep{
-				Name:    s.Name,
-				Actions: newa,
-			}
+		d.Steps = ownedSteps(d.Steps, withStepIDs(d.Steps, news), prune, "")
+		putOctopusData(d, apiURL("deploymentprocesses/"+p.DeploymentProcessID))
+		fmt.Println("Put Deployment Process")
 
-			news = append(news, ts)
+		if len(laterChannels) != 0 {
+			putChannels(p.ID, laterChannels)
 		}
 
-		d.Steps = news
-		putOctopusData(d, uri+"/api/deploymentprocesses/"+p.DeploymentProcessID)
-		fmt.Println("Put Deployment Process")
+		// New channels and actions are only in the scope values of the variable set once they are put
+		sv := octopusVariableSet{}
+		getOctopusData(&sv, apiURL("variables/"+p.VariableSetID))
+		v.ScopeValues = sv.ScopeValues
 
 		// Variables
-		v := octopusVariableSet{}
-		getOctopusData(&v, uri+"/api/variables/"+p.VariableSetID)
-		ds := v.ScopeValues.makeScopeDataSet()
+		verifyVersion("Variable set", apiURL("variables/"+p.VariableSetID), v.Version)
 
-		newv := []octopusVariable{}
+		v.Variables = ownedVariables(&v, v.keepSensitiveValues(v.resolveVariableScopes(flattenVariables(op.Variables))), prune, "")
+		putOctopusData(v, apiURL("variables/"+p.VariableSetID))
+		fmt.Println("Put Variables")
 
-		for _, sv := range op.Variables {
-			if sv.ScopedValues != nil {
-				for _, svv := range sv.ScopedValues {
-					thistype, err := verifyVariableType(sv)
-					if err != nil {
-						logAndExitf(err.Error())
-					}
-					if len(svv) == 1 {
-						tv := octopusVariable{
-							Name:        sv.Name,
-							Value:       svv["value"],
-							Type:        thistype,
-							Description: sv.Description,
-						}
-						newv = append(newv, tv)
-					} else {
-						scopes := make(map[string][]string)
-						for it, svt := range svv {
-							if it != "value" {
-								stype, err := verifyScopeType(it)
-								if err != nil {
-									logAndExitf(err.Error())
-								}
-								scopeIDs, _, err := v.ScopeValues.getScope(ds, svt, nil, stype)
-								if err != nil {
-									logAndExitf(err.Error())
-								}
-								scopes[it] = scopeIDs
-							}
-						}
-						tv := octopusVariable{
-							Name:        sv.Name,
-							Value:       svv["value"],
-							Scope:       scopes,
-							Type:        thistype,
-							Description: sv.Description,
-						}
-						newv = append(newv, tv)
-					}
-				}
-			}
-			thistype, err := verifyVariableType(sv)
-			if err != nil {
-				logAndExitf(err.Error())
-			}
-			if sv.Value != "" {
-				tv := octopusVariable{
-					Name:        sv.Name,
-					Value:       sv.Value,
-					Type:        thistype,
-					Description: sv.Description,
-				}
-				newv = append(newv, tv)
-			}
+		// Runbooks
+		if len(runbooks) != 0 {
+			putRunbooks(p.ID, runbooks, publish, prune)
 		}
 
-		v.Variables = newv
-		putOctopusData(v, uri+"/api/variables/"+p.VariableSetID)
-		fmt.Println("Put Variables")
+		// Triggers
+		if len(triggers) != 0 {
+			putTriggers(p.ID, triggers)
+		}
+
+		// Tenants
+		if len(op.Tenants) != 0 {
+			r.putTenants(p.ID, op.Tenants, p.Templates, lvs)
+		}
 
 		// End
 		finish := time.Now()
@@ -237,4 +338,87 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// putCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	putCmd.Flags().BoolP("plan", "p", false, "Print the changes put would make to Octopus without writing anything")
+	putCmd.Flags().StringP("plan-output", "o", "", "Write the planned changes as json to a file, or - for stdout. Implies --plan")
+	putCmd.Flags().StringP("lock", "l", "", "Abort if the deployment process or variables have changed in Octopus since this plan file was written")
+	putCmd.Flags().Bool("merge", true, "Keep steps and variables in Octopus that are not in octopipe.yaml, the default")
+	putCmd.Flags().Bool("prune", false, "Remove steps and variables in Octopus that are not in octopipe.yaml, listing each one before it is removed")
+	putCmd.Flags().Bool("publish-runbooks", false, "Publish a snapshot of each runbook in octopipe.yaml after it is put")
+}
+
+// flattenVariables expands the variables in octopipe.yaml into one Octopus variable per value.
+// Scopes are left as names, use resolveVariableScopes to convert them to Octopus Ids
+func flattenVariables(vars []variable) (newv []octopusVariable) {
+	newv = make([]octopusVariable, 0)
+
+	for _, sv := range vars {
+		thistype, err := verifyVariableType(sv)
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		sensitive := sv.Sensitive || thistype == "Sensitive"
+		if sensitive {
+			if thistype != "String" && thistype != "Sensitive" {
+				logAndExitf("Variable '%s' of type '%s' cannot be sensitive", sv.Name, thistype)
+			}
+			thistype = "Sensitive"
+		}
+		if sv.Prompt != nil && sv.Value == "" && sv.ScopedValues != nil {
+			logAndExitf("Variable '%s' has a prompt but only scoped values, put the prompt on the scoped values", sv.Name)
+		}
+		if sv.ScopedValues != nil {
+			for _, svv := range sv.ScopedValues {
+				tv := octopusVariable{
+					Name:        sv.Name,
+					Value:       svv.Value,
+					Type:        thistype,
+					Description: sv.Description,
+					IsSensitive: sensitive,
+					Prompt:      buildPrompt(sv.Name, svv.Prompt),
+				}
+				scopes := make(map[string][]string)
+				for it, svt := range svv.Scope {
+					stype, err := verifyScopeType(it)
+					if err != nil {
+						logAndExitf(err.Error())
+					}
+					scopes[stype] = strings.Split(svt, ",")
+				}
+				if len(scopes) > 0 {
+					tv.Scope = scopes
+				}
+				newv = append(newv, tv)
+			}
+		}
+		// A variable without scoped values always has an unscoped value, which can be empty. Sensitive
+		// variables without a value keep the value already set in Octopus, prompted variables are
+		// given one at deploy time
+		if sv.Value != "" || sv.ScopedValues == nil {
+			tv := octopusVariable{
+				Name:        sv.Name,
+				Value:       sv.Value,
+				Type:        thistype,
+				Description: sv.Description,
+				IsSensitive: sensitive,
+				Prompt:      buildPrompt(sv.Name, sv.Prompt),
+			}
+			newv = append(newv, tv)
+		}
+	}
+
+	return newv
+}
+
+// buildPrompt creates the Octopus prompt for a prompt in octopipe.yaml, the label defaults to the variable name
+func buildPrompt(name string, p *prompt) *octopusVariablePrompt {
+	if p == nil {
+		return nil
+	}
+
+	label := p.Label
+	if label == "" {
+		label = name
+	}
+
+	return &octopusVariablePrompt{Label: label, Description: p.Description, Required: p.Required}
 }
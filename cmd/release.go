@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// releaseCmd represents the release command
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Create releases of the project in octopipe.yaml",
+	Long: `
+Use the release command to create releases of the
+project in octopipe.yaml once put has written it to Octopus
+`,
+}
+
+// releaseCreateCmd represents the release create command
+var releaseCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a release of the project in octopipe.yaml",
+	Long: `
+Use release create to create a release of the project in
+octopipe.yaml and print its Id. The version defaults to the
+next version of the project and the channel to its default channel
+
+Packages use the latest version in their feed allowed by the
+version rules of the channel. Pass --package with the step name
+and version, or step:package:version for a named package
+in a step, to choose the version of a package
+
+Usage:
+
+octopipe release create
+octopipe release create --version 1.2.0 --channel Hotfix
+octopipe release create --package "Deploy Web:1.2.0-beta"
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		version, _ := cmd.Flags().GetString("version")
+		channelName, _ := cmd.Flags().GetString("channel")
+		packages, _ := cmd.Flags().GetStringArray("package")
+
+		if apiKey == "" || uri == "" {
+			logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
+		}
+
+		var op octopipe
+		op.importOctopipeFile()
+		selectSpace(op.Space)
+
+		p := octopusProject{}
+		getOctopusData(&p, apiURL("projects/"+getProjectSlug(op.Project.Name)))
+
+		c := getReleaseChannel(p.ID, channelName)
+
+		t := octopusReleaseTemplate{}
+		getOctopusData(&t, apiURL("deploymentprocesses/"+p.DeploymentProcessID+"/template?channel="+c.ID))
+
+		if version == "" {
+			version = t.NextVersionIncrement
+		}
+
+		r := octopusRelease{
+			ProjectID:        p.ID,
+			ChannelID:        c.ID,
+			Version:          version,
+			SelectedPackages: selectReleasePackages(t.Packages, c, packages),
+		}
+		postOctopusData(&r, apiURL("releases"))
+
+		fmt.Println(r.ID)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(releaseCmd)
+	releaseCmd.AddCommand(releaseCreateCmd)
+
+	releaseCreateCmd.Flags().StringP("version", "v", "", "The release version, the next version of the project if not specified")
+	releaseCreateCmd.Flags().StringP("channel", "c", "", "The channel of the release, the default channel if not specified")
+	releaseCreateCmd.Flags().StringArrayP("package", "k", nil, "The version of a package as step:version or step:package:version, can be repeated")
+}
+
+// getReleaseChannel finds a channel of the project by name, or the default channel if no name is given
+func getReleaseChannel(projectID string, name string) octopusChannel {
+	cs := getProjectChannels(projectID)
+
+	if name != "" {
+		c, err := getChannel(cs.Items, name, "")
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		return c
+	}
+
+	for _, c := range cs.Items {
+		if c.IsDefault {
+			return c
+		}
+	}
+
+	logAndExitf("Project has no default channel, specify the channel with --channel")
+	return octopusChannel{}
+}
+
+// splitPackageVersion splits a --package value into the action package and version
+func splitPackageVersion(pkg string) (ap octopusActionPackage, version string) {
+	i := strings.LastIndex(pkg, ":")
+	if i == -1 {
+		logAndExitf("Package '%s' must be in the format step:version or step:package:version", pkg)
+	}
+
+	return splitActionPackage(pkg[:i]), pkg[i+1:]
+}
+
+// latestPackageVersion returns the latest version of a package in its feed allowed by the version
+// rules of the channel
+func latestPackageVersion(tp octopusReleaseTemplatePackage, c octopusChannel) string {
+	query := url.Values{}
+	query.Set("packageId", tp.PackageID)
+	query.Set("take", "1")
+	for _, rule := range c.Rules {
+		for _, ap := range rule.ActionPackages {
+			if ap.DeploymentAction == tp.ActionName && ap.PackageReference == tp.PackageReferenceName {
+				query.Set("versionRange", rule.VersionRange)
+				query.Set("preReleaseTag", rule.Tag)
+			}
+		}
+	}
+
+	versions := octopusPackageVersions{}
+	getOctopusData(&versions, apiURL("feeds/"+tp.FeedID+"/packages/versions?"+query.Encode()))
+	if len(versions.Items) == 0 {
+		logAndExitf("No version of package '%s' for step '%s' found in its feed", tp.PackageID, tp.ActionName)
+	}
+
+	return versions.Items[0].Version
+}
+
+// selectReleasePackages picks the version of each package the deployment process deploys, using the
+// versions given with --package before the latest version in the feed
+func selectReleasePackages(tps []octopusReleaseTemplatePackage, c octopusChannel, packages []string) (sps []octopusSelectedPackage) {
+	sps = make([]octopusSelectedPackage, 0)
+
+	chosen := make(map[octopusActionPackage]string)
+	for _, pkg := range packages {
+		ap, version := splitPackageVersion(pkg)
+		found := false
+		for _, tp := range tps {
+			found = found || (tp.ActionName == ap.DeploymentAction && tp.PackageReferenceName == ap.PackageReference)
+		}
+		if !found {
+			logAndExitf("Package '%s' is not deployed by the deployment process", joinActionPackage(ap))
+		}
+		chosen[ap] = version
+	}
+
+	for _, tp := range tps {
+		ap := octopusActionPackage{DeploymentAction: tp.ActionName, PackageReference: tp.PackageReferenceName}
+		version, ok := chosen[ap]
+		if !ok {
+			if !tp.IsResolvable {
+				logAndExitf("The feed of package '%s' can't be resolved until deployment, specify its version with --package", joinActionPackage(ap))
+			}
+			version = latestPackageVersion(tp, c)
+		}
+		sps = append(sps, octopusSelectedPackage{ActionName: tp.ActionName, PackageReferenceName: tp.PackageReferenceName, Version: version})
+	}
+
+	return sps
+}
@@ -24,6 +24,7 @@ import (
 )
 
 var cfgFile string
+var spaceName string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -57,6 +58,7 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.octopipe.yaml)")
+	rootCmd.PersistentFlags().StringVar(&spaceName, "space", os.Getenv("OCTOPUS_SPACE"), "the name of the Octopus space to use, overriding the space in octopipe.yaml (default is $OCTOPUS_SPACE)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
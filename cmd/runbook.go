@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// runbookCmd represents the runbook command
+var runbookCmd = &cobra.Command{
+	Use:   "runbook",
+	Short: "Run the runbooks of the project in octopipe.yaml",
+	Long: `
+Use the runbook command to run the runbooks of the
+project in octopipe.yaml once put has written them to Octopus
+`,
+}
+
+// runbookRunCmd represents the runbook run command
+var runbookRunCmd = &cobra.Command{
+	Use:   "run <runbook>",
+	Short: "Run the published snapshot of a runbook",
+	Long: `
+Use runbook run to run the published snapshot of a runbook of
+the project in octopipe.yaml in an environment, optionally for
+a tenant. Publish runbooks with put --publish-runbooks
+
+Pass --wait to follow the run, printing its task log as it
+runs. Runbook run then exits non-zero if the run fails
+
+Usage:
+
+octopipe runbook run "Restart Pods" --environment Production
+octopipe runbook run "Flush Cache" --environment Production --tenant Contoso --wait
+
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		environment, _ := cmd.Flags().GetString("environment")
+		tenantName, _ := cmd.Flags().GetString("tenant")
+		wait, _ := cmd.Flags().GetBool("wait")
+
+		if apiKey == "" || uri == "" {
+			logAndExitf("Octopus Api Key and Octopus Uri must be specified in environment variables with names OCTOPUS_API_KEY and OCTOPUS_URI")
+		}
+		if environment == "" {
+			logAndExitf("The environment to run the runbook in must be specified with --environment")
+		}
+
+		var op octopipe
+		op.importOctopipeFile()
+		selectSpace(op.Space)
+
+		p := octopusProject{}
+		getOctopusData(&p, apiURL("projects/"+getProjectSlug(op.Project.Name)))
+
+		rbs := octopusRunbooks{}
+		getOctopusData(&rbs, apiURL("projects/"+p.ID+"/runbooks"))
+		rb, err := getRunbook(rbs.Items, args[0], "")
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		if rb.PublishedRunbookSnapshotID == "" {
+			logAndExitf("Runbook '%s' has no published snapshot, publish it with put --publish-runbooks", rb.Name)
+		}
+
+		es := octopusEnvironments{}
+		getOctopusData(&es, apiURL("environments/all"))
+		e, err := getEnvironment(es, environment, "")
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		if rb.EnvironmentScope == "Specified" && !containsString(rb.Environments, e.ID) {
+			logAndExitf("Runbook '%s' can't run in environment '%s'", rb.Name, e.Name)
+		}
+
+		run := octopusRunbookRun{RunbookID: rb.ID, RunbookSnapshotID: rb.PublishedRunbookSnapshotID, EnvironmentID: e.ID}
+		if tenantName != "" {
+			run.TenantID = getTenantID(tenantName)
+		}
+		postOctopusData(&run, apiURL("runbookRuns"))
+		fmt.Printf("Running runbook %s in %s (%s)\n", rb.Name, e.Name, run.ID)
+
+		if wait {
+			waitForTask(run.TaskID)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runbookCmd)
+	runbookCmd.AddCommand(runbookRunCmd)
+
+	runbookRunCmd.Flags().StringP("environment", "e", "", "The name of the environment to run the runbook in")
+	runbookRunCmd.Flags().StringP("tenant", "t", "", "The name of the tenant to run the runbook for")
+	runbookRunCmd.Flags().BoolP("wait", "w", false, "Wait for the run to finish, printing its task log")
+}
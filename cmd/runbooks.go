@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// builtRunbook is a runbook in octopipe.yaml with the steps of its process
+type builtRunbook struct {
+	runbook octopusRunbook
+	steps   []octopusDeploymentStep
+}
+
+// buildRunbooks creates the Octopus runbooks and their process steps for the runbooks in octopipe.yaml.
+// Runbooks without environments can run in every environment
+func (r *processResources) buildRunbooks(runbooks []runbook) (rbs []builtRunbook) {
+	rbs = make([]builtRunbook, 0)
+
+	for _, rb := range runbooks {
+		for _, b := range rbs {
+			if b.runbook.Name == rb.Name {
+				logAndExitf("Runbook '%s' is defined more than once", rb.Name)
+			}
+		}
+
+		tr := octopusRunbook{
+			Name:             rb.Name,
+			Description:      rb.Description,
+			EnvironmentScope: "All",
+			Environments:     make([]string, 0),
+		}
+		if len(rb.Environments) != 0 {
+			tr.EnvironmentScope = "Specified"
+			for _, name := range rb.Environments {
+				e, err := getEnvironment(r.environments, name, "")
+				if err != nil {
+					logAndExitf("%s for runbook '%s'", err.Error(), rb.Name)
+				}
+				tr.Environments = append(tr.Environments, e.ID)
+			}
+		}
+
+		if len(rb.Steps) == 0 {
+			logAndExitf("Runbook '%s' must have at least one step", rb.Name)
+		}
+
+		rbs = append(rbs, builtRunbook{runbook: tr, steps: r.buildDeploymentSteps(rb.Steps)})
+	}
+
+	return rbs
+}
+
+// runbookEnvironments describes the environments a runbook can run in, for plans
+func (r *processResources) runbookEnvironments(rb octopusRunbook) string {
+	switch rb.EnvironmentScope {
+	case "", "All":
+		return "all"
+	case "FromProjectLifecycles":
+		return "the project lifecycles"
+	}
+
+	return strings.Join(r.environmentNames(rb.Environments), ", ")
+}
+
+// planRunbooks compares runbooks by name, runbooks in Octopus but not in octopipe.yaml are left alone.
+// Steps are planned as RunbookStep changes identified by the runbook and step name
+func (r *processResources) planRunbooks(current []octopusRunbook, desired []builtRunbook, prune bool) (changes []planChange) {
+	for _, b := range desired {
+		rb, err := getRunbook(current, b.runbook.Name, "")
+		exists := err == nil
+
+		rc := planField("Runbook", b.runbook.Name, "Description", rb.Description, b.runbook.Description)
+		rc = append(rc, planField("Runbook", b.runbook.Name, "Environments", r.runbookEnvironments(rb), r.runbookEnvironments(b.runbook))...)
+
+		rp := octopusRunbookProcess{}
+		if exists {
+			getOctopusData(&rp, apiURL("runbookProcesses/"+rb.RunbookProcessID))
+			changes = append(changes, withResourceAction(rc, "change")...)
+		} else {
+			if len(rc) == 0 {
+				rc = []planChange{{Resource: "Runbook", ID: b.runbook.Name, Action: "add"}}
+			}
+			changes = append(changes, withResourceAction(rc, "add")...)
+		}
+
+		changes = append(changes, runbookStepChanges(b.runbook.Name, r.planDeploymentProcess(rp.Steps, plannedSteps(rp.Steps, b.steps, prune)))...)
+	}
+
+	return changes
+}
+
+func runbookStepChanges(runbook string, changes []planChange) []planChange {
+	for i := range changes {
+		changes[i].Resource = "RunbookStep"
+		changes[i].ID = runbook + ": " + changes[i].ID
+	}
+
+	return changes
+}
+
+// putRunbooks creates or updates the runbooks of a project and their processes, matching them by
+// name. With publish a snapshot of each runbook is published so it can be run
+func putRunbooks(projectID string, runbooks []builtRunbook, publish bool, prune bool) {
+	current := octopusRunbooks{}
+	getOctopusData(&current, apiURL("projects/"+projectID+"/runbooks"))
+
+	for _, b := range runbooks {
+		rb := b.runbook
+		rb.ProjectID = projectID
+		if existing, err := getRunbook(current.Items, rb.Name, ""); err == nil {
+			rb.ID = existing.ID
+			putOctopusFields(&rb, apiURL("runbooks/"+rb.ID), "Name", "Description", "EnvironmentScope", "Environments")
+		} else {
+			postOctopusData(&rb, apiURL("runbooks"))
+		}
+
+		rp := octopusRunbookProcess{}
+		getOctopusData(&rp, apiURL("runbookProcesses/"+rb.RunbookProcessID))
+		rp.Steps = ownedSteps(rp.Steps, withStepIDs(rp.Steps, b.steps), prune, " from runbook "+rb.Name)
+		putOctopusData(&rp, apiURL("runbookProcesses/"+rb.RunbookProcessID))
+		fmt.Println("Put Runbook " + rb.Name)
+
+		if publish {
+			publishRunbook(rb)
+		}
+	}
+}
+
+// publishRunbook creates and publishes a snapshot of a runbook, using the latest version of each
+// package it deploys
+func publishRunbook(rb octopusRunbook) {
+	t := octopusRunbookSnapshotTemplate{}
+	getOctopusData(&t, apiURL("runbooks/"+rb.ID+"/runbookSnapshotTemplate"))
+
+	s := octopusRunbookSnapshot{
+		ProjectID:        rb.ProjectID,
+		RunbookID:        rb.ID,
+		Name:             t.NextNameIncrement,
+		SelectedPackages: selectReleasePackages(t.Packages, octopusChannel{}, nil),
+	}
+	postOctopusData(&s, apiURL("runbookSnapshots?publish=true"))
+	fmt.Println("Published Runbook " + rb.Name + " snapshot " + s.Name)
+}
+
+// exportRunbooks returns the octopipe.yaml runbooks of a project, writing the scripts of each to
+// scripts/runbooks/<runbook> in base
+func (r *processResources) exportRunbooks(base string, projectID string) (rbs []runbook) {
+	current := octopusRunbooks{}
+	getOctopusData(&current, apiURL("projects/"+projectID+"/runbooks"))
+
+	for _, rb := range current.Items {
+		tr := runbook{Name: rb.Name, Description: rb.Description}
+
+		switch rb.EnvironmentScope {
+		case "Specified":
+			tr.Environments = r.environmentNames(rb.Environments)
+		case "FromProjectLifecycles":
+			exportNotice("Runbook '%s' runs in the environments of the project lifecycles which octopipe can't export, it will run in all environments", rb.Name)
+		}
+
+		dir := "scripts/runbooks/" + getProjectSlug(rb.Name)
+		err := os.MkdirAll(filepath.Join(base, dir), 0755)
+		if err != nil {
+			logAndExitf("Failed to create %s:\n%s", dir, err.Error())
+		}
+
+		rp := octopusRunbookProcess{}
+		getOctopusData(&rp, apiURL("runbookProcesses/"+rb.RunbookProcessID))
+		tr.Steps = r.exportDeploymentSteps(rp.Steps, base, dir)
+
+		rbs = append(rbs, tr)
+	}
+
+	return rbs
+}
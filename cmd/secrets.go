@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// secretProvider resolves a reference to a secret stored outside of octopipe.yaml
+type secretProvider interface {
+	resolve(ref string) (value string, err error)
+}
+
+// secretProviders maps the prefix of a variable value to the provider that resolves it
+var secretProviders = map[string]secretProvider{
+	"env:":        envSecretProvider{},
+	"file:":       fileSecretProvider{},
+	"vault:":      vaultSecretProvider{},
+	"keyvault://": &keyVaultSecretProvider{},
+}
+
+// envSecretProvider resolves env:NAME from an environment variable
+type envSecretProvider struct{}
+
+func (envSecretProvider) resolve(ref string) (value string, err error) {
+	value, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", errors.New("Environment variable '" + ref + "' is not set")
+	}
+
+	return value, nil
+}
+
+// fileSecretProvider resolves file:path from the contents of a file relative to octopipe.yaml
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) resolve(ref string) (value string, err error) {
+	contents, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// vaultSecretProvider resolves vault:path#key from Hashicorp Vault using VAULT_ADDR and VAULT_TOKEN.
+// Both KV version 1 and 2 secret engines are supported, the key defaults to 'value'
+type vaultSecretProvider struct{}
+
+func (vaultSecretProvider) resolve(ref string) (value string, err error) {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("Vault address and token must be specified in environment variables with names VAULT_ADDR and VAULT_TOKEN")
+	}
+
+	path := ref
+	key := "value"
+	if i := strings.LastIndex(ref, "#"); i != -1 {
+		path = ref[:i]
+		key = ref[i+1:]
+	}
+
+	httpreq, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	httpreq.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		httpreq.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	response, err := client.Do(httpreq)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	responsebody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		return "", errors.New("Failed to read secret '" + path + "' from Vault:\n" + string(responsebody))
+	}
+
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	err = json.Unmarshal(responsebody, &secret)
+	if err != nil {
+		return "", err
+	}
+
+	data := secret.Data
+	if _, ok := data["metadata"]; ok {
+		if inner, ok := data["data"].(map[string]interface{}); ok {
+			data = inner
+		}
+	}
+
+	field, ok := data[key]
+	if !ok {
+		return "", errors.New("Key '" + key + "' not found in Vault secret '" + path + "'")
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(field)
+
+	return string(b), err
+}
+
+// keyVaultSecretProvider resolves keyvault://vault/secret[/version] from Azure Key Vault, authenticating
+// with the client credentials in AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET.
+// AZURE_AUTHORITY_HOST and AZURE_KEYVAULT_ENDPOINT override the login and vault addresses
+type keyVaultSecretProvider struct {
+	token string
+}
+
+func (p *keyVaultSecretProvider) getToken() (token string, err error) {
+	if p.token != "" {
+		return p.token, nil
+	}
+
+	tenant := os.Getenv("AZURE_TENANT_ID")
+	clientID := os.Getenv("AZURE_CLIENT_ID")
+	secret := os.Getenv("AZURE_CLIENT_SECRET")
+	if tenant == "" || clientID == "" || secret == "" {
+		return "", errors.New("Azure client credentials must be specified in environment variables with names AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_CLIENT_SECRET")
+	}
+
+	authority := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = "https://login.microsoftonline.com"
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", clientID)
+	form.Set("client_secret", secret)
+	form.Set("scope", "https://vault.azure.net/.default")
+
+	response, err := client.PostForm(strings.TrimRight(authority, "/")+"/"+tenant+"/oauth2/v2.0/token", form)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	responsebody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		return "", errors.New("Failed to get an Azure access token:\n" + string(responsebody))
+	}
+
+	t := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.Unmarshal(responsebody, &t)
+	if err != nil {
+		return "", err
+	}
+
+	p.token = t.AccessToken
+	return p.token, nil
+}
+
+func (p *keyVaultSecretProvider) resolve(ref string) (value string, err error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", errors.New("Key Vault references must be in the format keyvault://<vault>/<secret>[/<version>]")
+	}
+
+	endpoint := os.Getenv("AZURE_KEYVAULT_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://" + parts[0] + ".vault.azure.net"
+	}
+
+	secreturi := strings.TrimRight(endpoint, "/") + "/secrets/" + parts[1]
+	if len(parts) == 3 {
+		secreturi = secreturi + "/" + parts[2]
+	}
+
+	token, err := p.getToken()
+	if err != nil {
+		return "", err
+	}
+
+	httpreq, err := http.NewRequest("GET", secreturi+"?api-version=7.4", nil)
+	if err != nil {
+		return "", err
+	}
+	httpreq.Header.Set("Authorization", "Bearer "+token)
+
+	response, err := client.Do(httpreq)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	responsebody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		return "", errors.New("Failed to read secret '" + parts[1] + "' from Key Vault '" + parts[0] + "':\n" + string(responsebody))
+	}
+
+	secret := struct {
+		Value string `json:"value"`
+	}{}
+	err = json.Unmarshal(responsebody, &secret)
+
+	return secret.Value, err
+}
+
+// secretEscape starts a value that begins with a secret provider prefix but is not a reference,
+// \env:PATH is the literal value env:PATH
+const secretEscape = `\`
+
+// unescapeValue returns a value without the escape before a secret provider prefix, only one escape
+// is removed so \\env:PATH is the literal value \env:PATH
+func unescapeValue(value string) string {
+	if strings.HasPrefix(value, secretEscape) && isSecretReference(strings.TrimLeft(value, secretEscape)) {
+		return strings.TrimPrefix(value, secretEscape)
+	}
+
+	return value
+}
+
+// escapeValue returns an Octopus value as it is written to octopipe.yaml, values that start with a
+// secret provider prefix, after any escapes, are escaped so put doesn't resolve them
+func escapeValue(value string) string {
+	if isSecretReference(strings.TrimLeft(value, secretEscape)) {
+		return secretEscape + value
+	}
+
+	return value
+}
+
+// resolveSecret returns the secret a variable value refers to, or the value itself if it is not a reference
+func resolveSecret(value string) (resolved string, secret bool, err error) {
+	for prefix, provider := range secretProviders {
+		if strings.HasPrefix(value, prefix) {
+			resolved, err = provider.resolve(strings.TrimPrefix(value, prefix))
+			if err != nil {
+				return "", true, errors.New("Failed to resolve secret '" + value + "':\n" + err.Error())
+			}
+			return resolved, true, nil
+		}
+	}
+
+	return unescapeValue(value), false, nil
+}
+
+// isSecretReference reports whether a variable value refers to a secret provider
+func isSecretReference(value string) bool {
+	for prefix := range secretProviders {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveSecrets replaces secret references in the project and library variable set variables
+// and the project template default values. Tenant values are resolved by verifyTenants, once the
+// templates they provide values for are known
+func (op *octopipe) resolveSecrets() {
+	resolveVariableSecrets(op.Variables)
+	for _, sv := range op.LibraryVariableSets {
+		resolveVariableSecrets(sv.Variables)
+	}
+	for i, t := range op.Project.Templates {
+		// Sensitive defaults are masked in plans and stored as sensitive values in Octopus
+		if isSecretReference(t.DefaultValue) && t.ControlType != "Sensitive" {
+			logAndExitf("Template '%s' has a secret reference for its default value but is not Sensitive, secret references can only be used for templates with controlType Sensitive", t.Name)
+		}
+
+		resolved, _, err := resolveSecret(t.DefaultValue)
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		op.Project.Templates[i].DefaultValue = resolved
+	}
+}
+
+// resolveVariableSecrets replaces secret references in variable values with the secrets they refer to.
+// Variables with a secret value are marked sensitive
+func resolveVariableSecrets(vars []variable) {
+	for i, sv := range vars {
+		resolved, secret, err := resolveSecret(sv.Value)
+		if err != nil {
+			logAndExitf(err.Error())
+		}
+		vars[i].Value = resolved
+		if secret {
+			vars[i].Sensitive = true
+		}
+
+		for j, svv := range sv.ScopedValues {
+			resolved, secret, err := resolveSecret(svv.Value)
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			vars[i].ScopedValues[j].Value = resolved
+			if secret {
+				vars[i].Sensitive = true
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setEnv sets an environment variable and returns a func that restores its previous value
+func setEnv(name string, value string) func() {
+	previous, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+
+	return func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// keyVaultStandIn serves the Azure token endpoint and the Key Vault secrets api
+func keyVaultStandIn(t *testing.T, tokens *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/tenant-1/oauth2/v2.0/token":
+			*tokens++
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "client-1" || r.Form.Get("client_secret") != "shh" {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			if r.Form.Get("scope") != "https://vault.azure.net/.default" {
+				t.Errorf("unexpected token scope %s", r.Form.Get("scope"))
+			}
+			w.Write([]byte(`{"access_token":"token-1","token_type":"Bearer"}`))
+		case r.Header.Get("Authorization") != "Bearer token-1":
+			w.WriteHeader(401)
+			w.Write([]byte(`{"error":{"code":"Unauthorized"}}`))
+		case r.URL.Query().Get("api-version") == "":
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":{"code":"MissingApiVersionParameter"}}`))
+		case r.URL.Path == "/secrets/db-password":
+			w.Write([]byte(`{"value":"latest","id":"db-password"}`))
+		case r.URL.Path == "/secrets/db-password/v1":
+			w.Write([]byte(`{"value":"first","id":"db-password/v1"}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":{"code":"SecretNotFound"}}`))
+		}
+	}))
+}
+
+func keyVaultEnv(server *httptest.Server) func() {
+	restores := []func(){
+		setEnv("AZURE_TENANT_ID", "tenant-1"),
+		setEnv("AZURE_CLIENT_ID", "client-1"),
+		setEnv("AZURE_CLIENT_SECRET", "shh"),
+		setEnv("AZURE_AUTHORITY_HOST", server.URL),
+		setEnv("AZURE_KEYVAULT_ENDPOINT", server.URL),
+	}
+
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+func TestKeyVaultSecretProvider(t *testing.T) {
+	tokens := 0
+	server := keyVaultStandIn(t, &tokens)
+	defer server.Close()
+	defer keyVaultEnv(server)()
+
+	p := &keyVaultSecretProvider{}
+	tests := []struct {
+		ref   string
+		value string
+		err   string
+	}{
+		{ref: "myvault/db-password", value: "latest"},
+		{ref: "myvault/db-password/v1", value: "first"},
+		{ref: "myvault/missing", err: "Failed to read secret 'missing' from Key Vault 'myvault'"},
+		{ref: "myvault", err: "keyvault://<vault>/<secret>[/<version>]"},
+	}
+
+	for _, tt := range tests {
+		value, err := p.resolve(tt.ref)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolve(%q) error = %v, want %q", tt.ref, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || value != tt.value {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.ref, value, err, tt.value)
+		}
+	}
+
+	if tokens != 1 {
+		t.Errorf("requested %d tokens, want the token to be requested once", tokens)
+	}
+}
+
+func TestKeyVaultSecretProviderWithoutCredentials(t *testing.T) {
+	defer setEnv("AZURE_CLIENT_SECRET", "")()
+
+	_, err := (&keyVaultSecretProvider{}).resolve("myvault/db-password")
+	if err == nil || !strings.Contains(err.Error(), "AZURE_CLIENT_SECRET") {
+		t.Errorf("resolve without credentials error = %v", err)
+	}
+}
+
+// TestKeyVaultFailureStopsPut runs put in a child process against an Octopus stand-in, a secret
+// that can't be read must stop put before it writes anything to Octopus
+func TestKeyVaultFailureStopsPut(t *testing.T) {
+	if dir := os.Getenv("OCTOPIPE_TEST_PUT_DIR"); dir != "" {
+		os.Chdir(dir)
+		rootCmd.SetArgs([]string{"put"})
+		Execute()
+		os.Exit(0)
+	}
+
+	var mu sync.Mutex
+	writes := make([]string, 0)
+	octopus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			mu.Lock()
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+		}
+		w.WriteHeader(404)
+		w.Write([]byte(`{"ErrorMessage":"not found"}`))
+	}))
+	defer octopus.Close()
+
+	tokens := 0
+	keyVault := keyVaultStandIn(t, &tokens)
+	defer keyVault.Close()
+
+	dir, err := ioutil.TempDir("", "octopipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yaml := `project:
+  name: Secrets.Test
+  group: Default Project Group
+  lifecycle: Default Lifecycle
+variables:
+- name: DbPassword
+  value: keyvault://myvault/missing
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "octopipe.yaml"), []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestKeyVaultFailureStopsPut")
+	cmd.Env = append(os.Environ(),
+		"OCTOPIPE_TEST_PUT_DIR="+dir,
+		"OCTOPUS_URI="+octopus.URL,
+		"OCTOPUS_API_KEY=API-TEST",
+		"AZURE_TENANT_ID=tenant-1",
+		"AZURE_CLIENT_ID=client-1",
+		"AZURE_CLIENT_SECRET=shh",
+		"AZURE_AUTHORITY_HOST="+keyVault.URL,
+		"AZURE_KEYVAULT_ENDPOINT="+keyVault.URL,
+	)
+	out, err := cmd.CombinedOutput()
+
+	if err == nil {
+		t.Fatalf("put succeeded with a secret that can't be read:\n%s", out)
+	}
+	if !strings.Contains(string(out), "Failed to resolve secret 'keyvault://myvault/missing'") {
+		t.Errorf("put output doesn't report the secret:\n%s", out)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writes) != 0 {
+		t.Errorf("put wrote to Octopus before failing: %v", writes)
+	}
+}
+
+// vaultStandIn serves a KV version 1 engine at secret/ and a KV version 2 engine at kv/
+func vaultStandIn(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token-1" {
+			w.WriteHeader(403)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		if ns := r.Header.Get("X-Vault-Namespace"); ns != "" && ns != "team-a" {
+			t.Errorf("unexpected namespace %s", ns)
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/aks":
+			w.Write([]byte(`{"data":{"value":"v1-value","password":"v1-password"}}`))
+		case "/v1/kv/data/aks":
+			w.Write([]byte(`{"data":{"data":{"value":"v2-value","password":"v2-password","port":5432},"metadata":{"version":3}}}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	server := vaultStandIn(t)
+	defer server.Close()
+	defer setEnv("VAULT_ADDR", server.URL+"/")()
+	defer setEnv("VAULT_TOKEN", "token-1")()
+	defer setEnv("VAULT_NAMESPACE", "team-a")()
+
+	tests := []struct {
+		ref   string
+		value string
+		err   string
+	}{
+		{ref: "secret/aks", value: "v1-value"},
+		{ref: "secret/aks#password", value: "v1-password"},
+		{ref: "/kv/data/aks", value: "v2-value"},
+		{ref: "kv/data/aks#password", value: "v2-password"},
+		{ref: "kv/data/aks#port", value: "5432"},
+		{ref: "kv/data/aks#user", err: "Key 'user' not found in Vault secret 'kv/data/aks'"},
+		{ref: "secret/missing", err: "Failed to read secret 'secret/missing' from Vault"},
+	}
+
+	for _, tt := range tests {
+		value, err := vaultSecretProvider{}.resolve(tt.ref)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolve(%q) error = %v, want %q", tt.ref, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || value != tt.value {
+			t.Errorf("resolve(%q) = %q, %v, want %q", tt.ref, value, err, tt.value)
+		}
+	}
+}
+
+func TestVaultSecretProviderErrors(t *testing.T) {
+	server := vaultStandIn(t)
+	defer server.Close()
+	defer setEnv("VAULT_ADDR", server.URL)()
+
+	defer setEnv("VAULT_TOKEN", "")()
+	if _, err := (vaultSecretProvider{}).resolve("secret/aks"); err == nil || !strings.Contains(err.Error(), "VAULT_TOKEN") {
+		t.Errorf("resolve without a token error = %v", err)
+	}
+
+	os.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := (vaultSecretProvider{}).resolve("secret/aks"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("resolve with the wrong token error = %v", err)
+	}
+}
+
+func TestResolveSecretEscape(t *testing.T) {
+	defer setEnv("OCTOPIPE_TEST_SECRET", "shh")()
+
+	tests := []struct {
+		value    string
+		resolved string
+		secret   bool
+	}{
+		{value: "env:OCTOPIPE_TEST_SECRET", resolved: "shh", secret: true},
+		{value: `\env:OCTOPIPE_TEST_SECRET`, resolved: "env:OCTOPIPE_TEST_SECRET"},
+		{value: `\file:notes.txt`, resolved: "file:notes.txt"},
+		{value: `\\server\share`, resolved: `\\server\share`},
+		{value: `\\env:PATH`, resolved: `\env:PATH`},
+		{value: "plain", resolved: "plain"},
+	}
+
+	for _, tt := range tests {
+		resolved, secret, err := resolveSecret(tt.value)
+		if err != nil || resolved != tt.resolved || secret != tt.secret {
+			t.Errorf("resolveSecret(%q) = %q, %v, %v, want %q, %v", tt.value, resolved, secret, err, tt.resolved, tt.secret)
+		}
+	}
+}
+
+func TestEscapeValueRoundTrips(t *testing.T) {
+	for _, value := range []string{"env:PROD", "file:///share", `\env:PATH`, `\\server\share`, "plain", ""} {
+		resolved, secret, err := resolveSecret(escapeValue(value))
+		if err != nil || secret || resolved != value {
+			t.Errorf("resolveSecret(escapeValue(%q)) = %q, %v, %v, want %q", value, resolved, secret, err, value)
+		}
+	}
+}
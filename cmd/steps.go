@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var scriptExtensions = map[string]string{
+	"PowerShell": "ps1",
+	"Bash":       "sh",
+	"FSharp":     "f",
+	"CSharp":     "c",
+}
+
+// processResources holds the Octopus resources that names in process steps are resolved against
+type processResources struct {
+	feeds        octopusFeeds
+	accounts     octopusAccounts
+	workerPools  octopusWorkerPools
+	environments octopusEnvironments
+	channels     octopusChannels
+	tagSets      octopusTagSets
+}
+
+// getProcessResources reads the resources process steps refer to, channels are
+// only read if the project already exists. A new project has the Default channel Octopus
+// creates with it, with its name as Id until the project is put
+func getProcessResources(projectID string) (r processResources) {
+	getOctopusData(&r.feeds, apiURL("feeds/all"))
+	getOctopusData(&r.accounts, apiURL("accounts/all"))
+	getOctopusData(&r.workerPools, apiURL("workerpools/all"))
+	getOctopusData(&r.environments, apiURL("environments/all"))
+	getOctopusData(&r.tagSets, apiURL("tagsets/all"))
+	if projectID != "" {
+		r.channels = getProjectChannels(projectID)
+	} else {
+		r.channels.Items = []octopusChannel{{ID: "Default", Name: "Default", IsDefault: true}}
+	}
+
+	return r
+}
+
+func readStepFile(s action) (string, error) {
+	taa, err := ioutil.ReadFile(s.File)
+	if err != nil {
+		return "", errors.New("Error opening " + s.File + ":\n" + err.Error())
+	}
+
+	return string(taa), nil
+}
+
+// buildDeploymentAction creates the Octopus action for a process step or child action in octopipe.yaml.
+// Script, Azure PowerShell, Kubernetes yaml and package steps have their own fields, any other
+// action type is built from its properties. Properties always override the generated ones
+func (r *processResources) buildDeploymentAction(s action, roles []string) (ta octopusDeploymentAction, err error) {
+	actionType := s.ActionType
+	if actionType == "" {
+		actionType = "Octopus.Script"
+	}
+
+	tap := make(map[string]string)
+
+	switch actionType {
+	case "Octopus.Script", "Octopus.AzurePowerShell":
+		if actionType == "Octopus.AzurePowerShell" && s.Type == "" {
+			s.Type = "PowerShell"
+		}
+
+		// Without a file the script source is expected in the properties
+		if s.File != "" || s.ActionType == "" {
+			thistype, err := verifySyntaxType(s)
+			if err != nil {
+				return ta, err
+			}
+			if actionType == "Octopus.AzurePowerShell" && thistype != "PowerShell" {
+				return ta, errors.New("Process step '" + s.Name + "' of type Octopus.AzurePowerShell must be a PowerShell script")
+			}
+			body, err := readStepFile(s)
+			if err != nil {
+				return ta, err
+			}
+
+			tap["Octopus.Action.Script.Syntax"] = thistype
+			tap["Octopus.Action.Script.ScriptSource"] = "Inline"
+			tap["Octopus.Action.Script.ScriptBody"] = body
+		}
+
+		if s.AzureAccount != "" {
+			account := s.AzureAccount
+			if !strings.HasPrefix(account, "#{") {
+				a, err := getAccount(r.accounts, account, account)
+				if err != nil {
+					return ta, err
+				}
+				account = a.ID
+			}
+			tap["Octopus.Action.Azure.AccountId"] = account
+		}
+	case "Octopus.KubernetesDeployRawYaml":
+		if s.File != "" {
+			body, err := readStepFile(s)
+			if err != nil {
+				return ta, err
+			}
+			tap["Octopus.Action.Script.ScriptSource"] = "Inline"
+			tap["Octopus.Action.KubernetesContainers.CustomResourceYaml"] = body
+		}
+	case "Octopus.TentaclePackage":
+		if len(s.Packages) == 0 {
+			return ta, errors.New("Process step '" + s.Name + "' of type Octopus.TentaclePackage must have a package")
+		}
+	}
+
+	// Scripts run on a worker by default and packages are deployed to targets
+	runOn := s.RunOn
+	if runOn == "" {
+		switch actionType {
+		case "Octopus.TentaclePackage":
+			runOn = "targets"
+		case "Octopus.Script", "Octopus.AzurePowerShell", "Octopus.KubernetesDeployRawYaml":
+			runOn = "server"
+		}
+	} else {
+		_, err := verifyRunOnType(s)
+		if err != nil {
+			return ta, err
+		}
+	}
+
+	location := "Server"
+	switch runOn {
+	case "server":
+		if actionType == "Octopus.TentaclePackage" {
+			return ta, errors.New("Process step '" + s.Name + "' of type Octopus.TentaclePackage can only run on targets")
+		}
+		tap["Octopus.Action.RunOnServer"] = "True"
+		ta.WorkerPoolID = getDefaultWorkerPool(r.workerPools).ID
+		if s.WorkerPool != "" {
+			wp, err := getWorkerPool(r.workerPools, s.WorkerPool, "")
+			if err != nil {
+				return ta, err
+			}
+			ta.WorkerPoolID = wp.ID
+		}
+	case "targets":
+		if s.WorkerPool != "" {
+			return ta, errors.New("Process step '" + s.Name + "' runs on targets and cannot have a worker pool")
+		}
+		if len(roles) == 0 {
+			return ta, errors.New("Process step '" + s.Name + "' runs on targets and must have at least one role")
+		}
+		if actionType == "Octopus.TentaclePackage" {
+			location = "ExecutionTarget"
+		} else {
+			tap["Octopus.Action.RunOnServer"] = "False"
+		}
+	}
+
+	packages := make([]octopusPackageReference, 0)
+	for _, sp := range s.Packages {
+		feedID := "feeds-builtin"
+		if sp.Feed != "" {
+			f, err := getFeed(r.feeds, sp.Feed, "")
+			if err != nil {
+				return ta, err
+			}
+			feedID = f.ID
+		}
+
+		packages = append(packages, octopusPackageReference{
+			Name:                sp.Name,
+			PackageID:           sp.ID,
+			FeedID:              feedID,
+			AcquisitionLocation: location,
+			Properties:          map[string]string{},
+		})
+
+		if sp.Name == "" && actionType == "Octopus.TentaclePackage" {
+			tap["Octopus.Action.Package.PackageId"] = sp.ID
+			tap["Octopus.Action.Package.FeedId"] = feedID
+		}
+	}
+
+	ta.Environments = make([]string, 0)
+	for _, name := range s.Environments {
+		e, err := getEnvironment(r.environments, name, "")
+		if err != nil {
+			return ta, err
+		}
+		ta.Environments = append(ta.Environments, e.ID)
+	}
+
+	ta.ExcludedEnvironments = make([]string, 0)
+	for _, name := range s.Excluded {
+		e, err := getEnvironment(r.environments, name, "")
+		if err != nil {
+			return ta, err
+		}
+		ta.ExcludedEnvironments = append(ta.ExcludedEnvironments, e.ID)
+	}
+
+	ta.Channels = make([]string, 0)
+	for _, name := range s.Channels {
+		c, err := getChannel(r.channels.Items, name, "")
+		if err != nil {
+			return ta, err
+		}
+		ta.Channels = append(ta.Channels, c.ID)
+	}
+
+	ta.TenantTags = make([]string, 0)
+	for _, name := range s.TenantTags {
+		t, err := getTenantTag(r.tagSets, name)
+		if err != nil {
+			return ta, err
+		}
+		ta.TenantTags = append(ta.TenantTags, t.CanonicalTagName)
+	}
+
+	for k, v := range s.Properties {
+		tap[k] = v
+	}
+
+	ta.Name = s.Name
+	ta.ActionType = actionType
+	ta.Properties = tap
+	ta.Packages = packages
+
+	return ta, nil
+}
+
+// buildDeploymentSteps creates the Octopus deployment steps for the process steps in octopipe.yaml
+func (r *processResources) buildDeploymentSteps(steps []step) (news []octopusDeploymentStep) {
+	news = make([]octopusDeploymentStep, 0)
+
+	for _, s := range steps {
+		ts := octopusDeploymentStep{
+			Name:       s.Name,
+			Properties: make(map[string]string),
+			Actions:    make([]octopusDeploymentAction, 0),
+		}
+
+		if len(s.Actions) == 0 {
+			a, err := r.buildDeploymentAction(s.action, s.Roles)
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			ts.Actions = append(ts.Actions, a)
+		} else {
+			if s.ActionType != "" || s.Type != "" || s.File != "" || s.AzureAccount != "" || len(s.Packages) > 0 ||
+				s.RunOn != "" || s.WorkerPool != "" || len(s.Environments) > 0 || len(s.Excluded) > 0 ||
+				len(s.Channels) > 0 || len(s.TenantTags) > 0 || len(s.Properties) > 0 {
+				logAndExitf("Process step '%s' has child actions, its action fields must be moved to a child action", s.Name)
+			}
+			for _, sa := range s.Actions {
+				a, err := r.buildDeploymentAction(sa, s.Roles)
+				if err != nil {
+					logAndExitf(err.Error())
+				}
+				ts.Actions = append(ts.Actions, a)
+			}
+		}
+
+		if len(s.Roles) > 0 {
+			ts.Properties["Octopus.Action.TargetRoles"] = strings.Join(s.Roles, ",")
+		}
+		if s.MaxParallelism != "" {
+			ts.Properties["Octopus.Action.MaxParallelism"] = s.MaxParallelism
+		}
+
+		ts.Condition = "Success"
+		if s.Condition != "" {
+			condition, expression, err := verifyConditionType(s)
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			ts.Condition = condition
+			if expression != "" {
+				ts.Properties["Octopus.Step.ConditionVariableExpression"] = expression
+			}
+		}
+
+		ts.StartTrigger = "StartAfterPrevious"
+		if s.StartTrigger != "" {
+			trigger, err := verifyStartTriggerType(s)
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			ts.StartTrigger = trigger
+		}
+
+		news = append(news, ts)
+	}
+
+	return news
+}
+
+// withStepIDs gives steps and actions the Id of the existing step or action with the same name, so
+// Octopus updates them rather than creating new ones and variables scoped to actions keep their scope
+func withStepIDs(current []octopusDeploymentStep, desired []octopusDeploymentStep) []octopusDeploymentStep {
+	actionIDs := make(map[string]string)
+	for _, cs := range current {
+		for _, ca := range cs.Actions {
+			actionIDs[ca.Name] = ca.ID
+		}
+	}
+
+	for i, ds := range desired {
+		if cs, err := getStep(current, ds.Name); err == nil {
+			desired[i].ID = cs.ID
+		}
+		for j, da := range ds.Actions {
+			desired[i].Actions[j].ID = actionIDs[da.Name]
+		}
+	}
+
+	return desired
+}
+
+// exportDeploymentAction writes the script or yaml of an Octopus action to the scripts directory
+// given, relative to base, and returns the octopipe.yaml action for it. An action put couldn't
+// build from octopipe.yaml returns an error and leaves nothing on disk
+func (r *processResources) exportDeploymentAction(a octopusDeploymentAction, roles []string, base string, dir string) (ts action, err error) {
+	inline := a.Properties["Octopus.Action.Script.ScriptSource"] == "Inline"
+
+	for _, ID := range a.Environments {
+		e, _ := getEnvironment(r.environments, "", ID)
+		ts.Environments = append(ts.Environments, e.Name)
+	}
+	for _, ID := range a.ExcludedEnvironments {
+		e, _ := getEnvironment(r.environments, "", ID)
+		ts.Excluded = append(ts.Excluded, e.Name)
+	}
+	for _, ID := range a.Channels {
+		c, _ := getChannel(r.channels.Items, "", ID)
+		ts.Channels = append(ts.Channels, c.Name)
+	}
+	ts.TenantTags = a.TenantTags
+
+	switch a.Properties["Octopus.Action.RunOnServer"] {
+	case "True", "true":
+		ts.RunOn = "server"
+		if a.WorkerPoolID != "" && a.WorkerPoolID != getDefaultWorkerPool(r.workerPools).ID {
+			ts.WorkerPool = a.WorkerPoolID
+			if wp, err := getWorkerPool(r.workerPools, "", a.WorkerPoolID); err == nil {
+				ts.WorkerPool = wp.Name
+			}
+		}
+	case "False", "false":
+		ts.RunOn = "targets"
+	}
+
+	ts.Name = a.Name
+	if a.ActionType != "Octopus.Script" || !inline {
+		ts.ActionType = a.ActionType
+	}
+	filename := dir + "/" + getProjectSlug(a.Name)
+	contents := ""
+
+	switch a.ActionType {
+	case "Octopus.Script", "Octopus.AzurePowerShell":
+		if inline {
+			ts.Type = a.Properties["Octopus.Action.Script.Syntax"]
+			ts.File = filename + "." + scriptExtensions[ts.Type]
+			contents = a.Properties["Octopus.Action.Script.ScriptBody"]
+		}
+		if a.ActionType == "Octopus.AzurePowerShell" {
+			ts.AzureAccount = a.Properties["Octopus.Action.Azure.AccountId"]
+			if account, err := getAccount(r.accounts, "", ts.AzureAccount); err == nil {
+				ts.AzureAccount = account.Name
+			}
+		}
+	case "Octopus.KubernetesDeployRawYaml":
+		if inline {
+			ts.File = filename + ".yaml"
+			contents = a.Properties["Octopus.Action.KubernetesContainers.CustomResourceYaml"]
+		}
+	}
+
+	if ts.File != "" {
+		err := ioutil.WriteFile(filepath.Join(base, ts.File), []byte(contents), 0644)
+		if err != nil {
+			logAndExitf("Failed to write deployment script to disk:\n%s\n", err.Error())
+		}
+	}
+
+	for _, pr := range a.Packages {
+		sp := stepPackage{Name: pr.Name, ID: pr.PackageID}
+		if pr.FeedID != "feeds-builtin" {
+			sp.Feed = pr.FeedID
+			if f, err := getFeed(r.feeds, "", pr.FeedID); err == nil {
+				sp.Feed = f.Name
+			}
+		}
+		ts.Packages = append(ts.Packages, sp)
+	}
+
+	// Compare with the action put would build, reading the script just written from base
+	generate := func(ts action) (octopusDeploymentAction, error) {
+		if ts.File != "" {
+			ts.File = filepath.Join(base, ts.File)
+		}
+		return r.buildDeploymentAction(ts, roles)
+	}
+
+	// Only write run on if it is not the default for the action type
+	if ts.RunOn != "" {
+		runOn := ts.RunOn
+		ts.RunOn = ""
+		if generated, err := generate(ts); err != nil || generated.Properties["Octopus.Action.RunOnServer"] != a.Properties["Octopus.Action.RunOnServer"] {
+			ts.RunOn = runOn
+		}
+	}
+
+	// Keep any property that put would not generate from the fields above
+	generated, err := generate(ts)
+	if err != nil {
+		if ts.File != "" {
+			os.Remove(filepath.Join(base, ts.File))
+		}
+		return action{}, err
+	}
+	for k, v := range a.Properties {
+		if gv, ok := generated.Properties[k]; !ok || gv != v {
+			if ts.Properties == nil {
+				ts.Properties = make(map[string]string)
+			}
+			ts.Properties[k] = v
+		}
+	}
+
+	if a.IsDisabled {
+		exportNotice("Step '%s' is disabled which octopipe can't export, it will be enabled when put", a.Name)
+	}
+	if a.IsRequired {
+		exportNotice("Step '%s' is required which octopipe can't export, it can be skipped once put", a.Name)
+	}
+	if a.Notes != "" {
+		exportNotice("Step '%s' has notes which octopipe can't export, they are removed when put", a.Name)
+	}
+	if a.Container != nil && a.Container.Image != "" {
+		exportNotice("Step '%s' runs in container '%s' which octopipe can't export, it runs on the worker when put", a.Name, a.Container.Image)
+	}
+	if a.WorkerPoolVariable != "" {
+		exportNotice("Step '%s' takes its worker pool from variable '%s' which octopipe can't export, it uses the default pool when put", a.Name, a.WorkerPoolVariable)
+	}
+	for _, name := range a.sensitiveProperties {
+		exportNotice("Step '%s' has a sensitive value for property '%s' which isn't exported, it is removed when put", a.Name, name)
+	}
+
+	return ts, nil
+}
+
+// exportDeploymentSteps returns the octopipe.yaml steps for an Octopus deployment or runbook process,
+// writing their scripts to the directory given, relative to base
+func (r *processResources) exportDeploymentSteps(steps []octopusDeploymentStep, base string, dir string) (dsa []step) {
+	dsa = make([]step, 0)
+
+	for _, s := range steps {
+		ts := step{}
+
+		if roles := s.Properties["Octopus.Action.TargetRoles"]; roles != "" {
+			ts.Roles = strings.Split(roles, ",")
+		}
+		ts.MaxParallelism = s.Properties["Octopus.Action.MaxParallelism"]
+
+		switch s.Condition {
+		case "Variable":
+			ts.Condition = s.Properties["Octopus.Step.ConditionVariableExpression"]
+		case "Failure", "Always":
+			ts.Condition = s.Condition
+		}
+
+		if s.StartTrigger == "StartWithPrevious" {
+			ts.StartTrigger = "parallel"
+		}
+		if s.PackageRequirement != "" && s.PackageRequirement != "LetOctopusDecide" {
+			exportNotice("Step '%s' has package requirement %s which octopipe can't export, Octopus decides when put", s.Name, s.PackageRequirement)
+		}
+
+		// A step with an action put can't build is left out, put keeps steps that aren't in
+		// octopipe.yaml unless --prune is passed
+		var err error
+		if len(s.Actions) == 1 {
+			ts.action, err = r.exportDeploymentAction(s.Actions[0], ts.Roles, base, dir)
+		} else {
+			ts.Name = s.Name
+			for _, a := range s.Actions {
+				var ta action
+				ta, err = r.exportDeploymentAction(a, ts.Roles, base, dir)
+				if err != nil {
+					break
+				}
+				ts.Actions = append(ts.Actions, ta)
+			}
+		}
+		if err != nil {
+			for _, ta := range ts.Actions {
+				if ta.File != "" {
+					os.Remove(filepath.Join(base, ta.File))
+				}
+			}
+			exportNotice("Step '%s' isn't exported as put can't build it (%s), put leaves it as it is unless --prune is passed", s.Name, err.Error())
+			continue
+		}
+
+		dsa = append(dsa, ts)
+	}
+
+	return dsa
+}
@@ -39,6 +39,7 @@ Examples:
 octopipe sub scripts/ DevTest
 octopipe sub -c scripts/ Production
 octopipe sub -c -f deploystep1.ps1,deploystep2.ps1 scripts/ DevTest
+octopipe sub -s scripts/ DevTest
 
 `,
 	Args: cobra.MinimumNArgs(2),
@@ -46,12 +47,24 @@ octopipe sub -c -f deploystep1.ps1,deploystep2.ps1 scripts/ DevTest
 
 		co, _ := cmd.Flags().GetBool("check-only")
 		fo, _ := cmd.Flags().GetString("filenames")
+		rs, _ := cmd.Flags().GetBool("secrets")
 		sdir := args[0]
 		scopes := args[1]
 
 		var op octopipe
 		op.importOctopipeFile()
 
+		// Secret references are only resolved when asked for, otherwise they are reported as not found.
+		// Resolving also removes the escape from values that start with a provider prefix, so once
+		// resolved no value is a reference
+		literal := unescapeValue
+		reference := isSecretReference
+		if rs {
+			op.resolveSecrets()
+			literal = func(value string) string { return value }
+			reference = func(value string) bool { return false }
+		}
+
 		asc := strings.Split(scopes, ",")
 		sc := make(map[string]string)
 		for _, tsc := range asc {
@@ -59,21 +72,31 @@ octopipe sub -c -f deploystep1.ps1,deploystep2.ps1 scripts/ DevTest
 			sc[tssc[0]] = tssc[1]
 		}
 
+		// Library variable set values come first so project variables with the same name replace them
+		vars := make([]variable, 0)
+		for _, sv := range op.LibraryVariableSets {
+			vars = append(vars, sv.Variables...)
+		}
+		vars = append(vars, op.Variables...)
+
 		vmap := make(map[string]string)
-		for _, thisv := range op.Variables {
-			if thisv.Value != "" {
-				vmap[thisv.Name] = thisv.Value
+		for _, thisv := range vars {
+			if thisv.Value != "" && !reference(thisv.Value) {
+				vmap[thisv.Name] = literal(thisv.Value)
 			} else if thisv.ScopedValues != nil {
 				for i, rsc := range sc {
 					for _, scv := range thisv.ScopedValues {
-						if len(scv) == 1 {
+						if reference(scv.Value) {
+							continue
+						}
+						if len(scv.Scope) == 0 {
 							if vmap[thisv.Name] == "" {
-								vmap[thisv.Name] = scv["value"]
+								vmap[thisv.Name] = literal(scv.Value)
 							}
 						}
-						regsc, _ := regexp.Match(rsc, []byte(scv[i]))
+						regsc, _ := regexp.Match(rsc, []byte(scv.Scope[i]))
 						if regsc {
-							vmap[thisv.Name] = scv["value"]
+							vmap[thisv.Name] = literal(scv.Value)
 						}
 					}
 				}
@@ -175,4 +198,5 @@ func init() {
 	// inCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	subCmd.Flags().BoolP("check-only", "c", false, "Check only for variables not present in octopipe.yaml (do not sub)")
 	subCmd.Flags().StringP("filenames", "f", "", "File names, separated by comma. If not specifed all files in directory are subbed")
+	subCmd.Flags().BoolP("secrets", "s", false, "Resolve env:, file:, vault: and keyvault:// secret references in variable values and sub them in")
 }
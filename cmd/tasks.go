@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// How often a running task is checked for new log lines
+const taskPollInterval = 5 * time.Second
+
+// How many of the latest lines of each activity are fetched on every poll, an activity that writes
+// more than this between polls has the lines in between skipped
+const taskLogTail = 100
+
+// taskLog prints the lines of an Octopus task log as they are written, remembering the last line of
+// each activity it has already printed
+type taskLog struct {
+	last map[string]octopusActivityLogElement
+}
+
+// unprinted returns the index of the first line of the activity that hasn't been printed yet, or -1
+// if lines were written between polls that are no longer in the tail
+func (l *taskLog) unprinted(a octopusActivityElement) int {
+	if last, ok := l.last[a.ID]; ok {
+		for i := len(a.LogElements) - 1; i >= 0; i-- {
+			if a.LogElements[i] == last {
+				return i + 1
+			}
+		}
+	}
+	if len(a.LogElements) >= taskLogTail {
+		return -1
+	}
+	return 0
+}
+
+// print writes the log lines of the activity and its children that haven't been printed yet
+func (l *taskLog) print(a octopusActivityElement) {
+	if from := l.unprinted(a); from < len(a.LogElements) {
+		if _, ok := l.last[a.ID]; !ok && a.Name != "" {
+			fmt.Println("== " + a.Name + " ==")
+		}
+		if from < 0 {
+			fmt.Println("...")
+			from = 0
+		}
+		for _, e := range a.LogElements[from:] {
+			if e.Category != "Info" {
+				fmt.Printf("%s: %s\n", e.Category, e.MessageText)
+			} else {
+				fmt.Println(e.MessageText)
+			}
+		}
+		l.last[a.ID] = a.LogElements[len(a.LogElements)-1]
+	}
+
+	for _, c := range a.Children {
+		l.print(c)
+	}
+}
+
+// waitForTask polls an Octopus task until it completes, streaming its log to stdout, and exits
+// non-zero if it didn't finish successfully
+func waitForTask(taskID string) {
+	l := taskLog{last: make(map[string]octopusActivityLogElement)}
+
+	for {
+		details := octopusTaskDetails{}
+		getOctopusData(&details, apiURL(fmt.Sprintf("tasks/%s/details?verbose=true&tail=%d", taskID, taskLogTail)))
+		for _, a := range details.ActivityLogs {
+			l.print(a)
+		}
+
+		t := details.Task
+		if t.IsCompleted {
+			if !t.FinishedSuccessfully {
+				logAndExitf("%s %s: %s", t.Description, t.State, t.ErrorMessage)
+			}
+			fmt.Printf("%s %s\n", t.Description, t.State)
+			return
+		}
+
+		time.Sleep(taskPollInterval)
+	}
+}
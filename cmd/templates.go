@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"strings"
+)
+
+// buildTemplates creates the Octopus project templates for the templates in octopipe.yaml. Templates
+// keep the Id of the existing template with the same name, as tenant values refer to them by Id, and
+// sensitive templates without a default value keep the one already set
+func buildTemplates(templates []template, current []octopusTemplate) (ts []octopusTemplate) {
+	ts = make([]octopusTemplate, 0)
+
+	for _, t := range templates {
+		controlType := "SingleLineText"
+		if t.ControlType != "" {
+			ct, err := verifyControlType(t)
+			if err != nil {
+				logAndExitf(err.Error())
+			}
+			controlType = ct
+		}
+		if _, err := getTemplate(ts, t.Name, ""); err == nil {
+			logAndExitf("Template '%s' is defined more than once", t.Name)
+		}
+
+		tt := octopusTemplate{
+			Name:            t.Name,
+			Label:           t.Label,
+			HelpText:        t.HelpText,
+			DefaultValue:    t.DefaultValue,
+			DisplaySettings: map[string]string{"Octopus.ControlType": controlType},
+		}
+
+		if len(t.Options) != 0 {
+			if controlType != "Select" {
+				logAndExitf("Template '%s' has options, options can only be used with the Select control type", t.Name)
+			}
+			options := make([]string, 0)
+			for _, o := range t.Options {
+				display := o.Display
+				if display == "" {
+					display = o.Value
+				}
+				options = append(options, o.Value+"|"+display)
+			}
+			tt.DisplaySettings["Octopus.SelectOptions"] = strings.Join(options, "\n")
+		}
+
+		ct, err := getTemplate(current, t.Name, "")
+		if err == nil {
+			tt.ID = ct.ID
+		}
+		if controlType == "Sensitive" {
+			if t.DefaultValue != "" {
+				tt.DefaultValue = map[string]interface{}{"HasValue": true, "NewValue": t.DefaultValue}
+			} else if err == nil && isSensitiveTemplate(ct) {
+				tt.DefaultValue = ct.DefaultValue
+			} else {
+				tt.DefaultValue = nil
+			}
+		}
+
+		ts = append(ts, tt)
+	}
+
+	return ts
+}
+
+// templateDefault returns the default value of a template for display, masking sensitive values
+func templateDefault(t octopusTemplate) string {
+	if isSensitiveTemplate(t) {
+		if v, ok := t.DefaultValue.(map[string]interface{}); ok && v["NewValue"] != nil {
+			return "<new sensitive value>"
+		}
+	}
+
+	return displayTenantValue(t.DefaultValue)
+}
+
+func planTemplate(current octopusTemplate, desired octopusTemplate) (changes []planChange) {
+	changes = append(changes, planField("Template", desired.Name, "Label", current.Label, desired.Label)...)
+	changes = append(changes, planField("Template", desired.Name, "HelpText", current.HelpText, desired.HelpText)...)
+	changes = append(changes, planField("Template", desired.Name, "DefaultValue", templateDefault(current), templateDefault(desired))...)
+	changes = append(changes, planField("Template", desired.Name, "ControlType", current.DisplaySettings["Octopus.ControlType"], desired.DisplaySettings["Octopus.ControlType"])...)
+	changes = append(changes, planField("Template", desired.Name, "Options", current.DisplaySettings["Octopus.SelectOptions"], desired.DisplaySettings["Octopus.SelectOptions"])...)
+
+	return changes
+}
+
+// planTemplates compares project templates by name, templates not in octopipe.yaml are removed
+func planTemplates(current []octopusTemplate, desired []octopusTemplate) (changes []planChange) {
+	for _, t := range desired {
+		ct, err := getTemplate(current, t.Name, "")
+		if err != nil {
+			changes = append(changes, withResourceAction(planTemplate(octopusTemplate{}, t), "add")...)
+			continue
+		}
+		changes = append(changes, withResourceAction(planTemplate(ct, t), "change")...)
+	}
+
+	for _, t := range current {
+		if _, err := getTemplate(desired, t.Name, ""); err != nil {
+			changes = append(changes, planChange{Resource: "Template", ID: t.Name, Action: "remove", ResourceAction: "remove"})
+		}
+	}
+
+	return changes
+}
+
+// exportTemplates returns the octopipe.yaml templates for the templates of a project, sensitive
+// default values are left out
+func exportTemplates(templates []octopusTemplate) (ts []template) {
+	for _, t := range templates {
+		tt := template{
+			Name:     t.Name,
+			Label:    t.Label,
+			HelpText: t.HelpText,
+		}
+
+		if value, ok := t.DefaultValue.(string); ok {
+			tt.DefaultValue = escapeValue(value)
+		}
+		if ct := t.DisplaySettings["Octopus.ControlType"]; ct != "SingleLineText" {
+			tt.ControlType = ct
+		}
+
+		if options := t.DisplaySettings["Octopus.SelectOptions"]; options != "" {
+			for _, line := range strings.Split(options, "\n") {
+				o := templateOption{Value: line}
+				if i := strings.Index(line, "|"); i != -1 {
+					o.Value = line[:i]
+					if line[i+1:] != o.Value {
+						o.Display = line[i+1:]
+					}
+				}
+				tt.Options = append(tt.Options, o)
+			}
+		}
+
+		ts = append(ts, tt)
+	}
+
+	return ts
+}
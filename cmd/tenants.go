@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// isSensitiveTemplate reports whether tenants provide a sensitive value for the template
+func isSensitiveTemplate(t octopusTemplate) bool {
+	return t.DisplaySettings["Octopus.ControlType"] == "Sensitive"
+}
+
+// tenantValue returns the Octopus value for a template, sensitive values are set through NewValue
+func tenantValue(t octopusTemplate, value string) interface{} {
+	if isSensitiveTemplate(t) {
+		return map[string]interface{}{"HasValue": true, "NewValue": value}
+	}
+
+	return value
+}
+
+// displayTenantValue returns a tenant value for display, masking sensitive values
+func displayTenantValue(v interface{}) string {
+	switch tv := v.(type) {
+	case string:
+		return tv
+	case map[string]interface{}:
+		if hasValue, _ := tv["HasValue"].(bool); hasValue {
+			return "<sensitive>"
+		}
+	}
+
+	return ""
+}
+
+// tenantEnvironmentValues returns the template values for a tenant in an environment
+func tenantEnvironmentValues(t tenant, environment string) map[string]string {
+	values := make(map[string]string)
+	for name, value := range t.Variables {
+		values[name] = value
+	}
+	for name, value := range t.EnvironmentVariables[environment] {
+		values[name] = value
+	}
+
+	return values
+}
+
+// verifyTenants checks the environments, templates and library variable sets tenants in octopipe.yaml
+// refer to exist, before anything is put. Secret references in tenant values are resolved here, they
+// are only allowed for sensitive templates so the secret is never shown or stored in clear
+func (r *processResources) verifyTenants(tenants []tenant, tenancy string, templates []octopusTemplate, lvs octopusLibraryVariableSets) {
+	if len(tenants) != 0 && tenancy == "Untenanted" {
+		logAndExitf("Tenants can only be connected to a project that is Tenanted or TenantedOrUntenanted")
+	}
+
+	for _, t := range tenants {
+		if len(t.Environments) == 0 {
+			logAndExitf("Tenant '%s' must be connected to at least one environment", t.Name)
+		}
+		for _, name := range t.Environments {
+			if _, err := getEnvironment(r.environments, name, ""); err != nil {
+				logAndExitf("%s for tenant '%s'", err.Error(), t.Name)
+			}
+		}
+
+		for env, values := range t.EnvironmentVariables {
+			if !containsString(t.Environments, env) {
+				logAndExitf("Tenant '%s' has variables for environment '%s' which it is not connected to", t.Name, env)
+			}
+			for name := range values {
+				tmpl, err := getTemplate(templates, name, "")
+				if err != nil {
+					logAndExitf("Project %s for tenant '%s'", err.Error(), t.Name)
+				}
+				resolveTenantSecret(t.Name, tmpl, values, name)
+			}
+		}
+		for name := range t.Variables {
+			tmpl, err := getTemplate(templates, name, "")
+			if err != nil {
+				logAndExitf("Project %s for tenant '%s'", err.Error(), t.Name)
+			}
+			resolveTenantSecret(t.Name, tmpl, t.Variables, name)
+		}
+
+		for set, values := range t.CommonVariables {
+			lv, err := getLibraryVariableSet(lvs, set, "")
+			if err != nil {
+				logAndExitf("%s for tenant '%s'", err.Error(), t.Name)
+			}
+			for name := range values {
+				tmpl, err := getTemplate(lv.Templates, name, "")
+				if err != nil {
+					logAndExitf("%s in library variable set '%s' for tenant '%s'", err.Error(), set, t.Name)
+				}
+				resolveTenantSecret(t.Name, tmpl, values, name)
+			}
+		}
+	}
+}
+
+// resolveTenantSecret replaces a secret reference in a tenant value with the secret it refers to,
+// and removes the escape from a value that isn't a reference
+func resolveTenantSecret(tenant string, t octopusTemplate, values map[string]string, name string) {
+	if isSecretReference(values[name]) && !isSensitiveTemplate(t) {
+		logAndExitf("Tenant '%s' has a secret reference for template '%s' which is not Sensitive, secret references can only be used for Sensitive templates", tenant, name)
+	}
+
+	resolved, _, err := resolveSecret(values[name])
+	if err != nil {
+		logAndExitf(err.Error())
+	}
+	values[name] = resolved
+}
+
+// planTenantValue compares a template value, sensitive values can't be compared so are always set
+func planTenantValue(ID string, field string, t octopusTemplate, current interface{}, desired string) []planChange {
+	old := displayTenantValue(current)
+	new := desired
+	if isSensitiveTemplate(t) {
+		new = "<new sensitive value>"
+	}
+
+	return planField("Tenant", ID, field, old, new)
+}
+
+func (r *processResources) planTenants(projectID string, tenants []tenant, templates []octopusTemplate, lvs octopusLibraryVariableSets) (changes []planChange) {
+	ots := octopusTenants{}
+	if len(tenants) != 0 {
+		getOctopusData(&ots, apiURL("tenants/all"))
+	}
+
+	for _, t := range tenants {
+		ot, err := getTenant(ots, t.Name, "")
+		exists := err == nil
+
+		tv := octopusTenantVariables{}
+		if exists && projectID != "" {
+			getOctopusData(&tv, apiURL("tenants/"+ot.ID+"/variables"))
+		}
+		pv := tv.ProjectVariables[projectID]
+
+		tc := make([]planChange, 0)
+		desired := append([]string{}, t.Environments...)
+		sort.Strings(desired)
+		tc = append(tc, planField("Tenant", t.Name, "Environments", strings.Join(r.environmentNames(ot.ProjectEnvironments[projectID]), ", "), strings.Join(desired, ", "))...)
+
+		for _, env := range desired {
+			e, _ := getEnvironment(r.environments, env, "")
+			values := tenantEnvironmentValues(t, env)
+			for _, name := range sortedKeys(values) {
+				tmpl, _ := getTemplate(templates, name, "")
+				tc = append(tc, planTenantValue(t.Name, name+" ["+env+"]", tmpl, pv.Variables[e.ID][tmpl.ID], values[name])...)
+			}
+		}
+
+		sets := make([]string, 0)
+		for set := range t.CommonVariables {
+			sets = append(sets, set)
+		}
+		sort.Strings(sets)
+		for _, set := range sets {
+			lv, _ := getLibraryVariableSet(lvs, set, "")
+			values := t.CommonVariables[set]
+			for _, name := range sortedKeys(values) {
+				tmpl, _ := getTemplate(lv.Templates, name, "")
+				tc = append(tc, planTenantValue(t.Name, set+"/"+name, tmpl, tv.LibraryVariables[lv.ID].Variables[tmpl.ID], values[name])...)
+			}
+		}
+
+		if !exists {
+			changes = append(changes, withResourceAction(tc, "add")...)
+		} else {
+			changes = append(changes, withResourceAction(tc, "change")...)
+		}
+	}
+
+	return changes
+}
+
+// putTenants creates the tenants in octopipe.yaml if they don't exist, connects them to the project
+// and sets the template values they list. Values not in octopipe.yaml are left as they are
+func (r *processResources) putTenants(projectID string, tenants []tenant, templates []octopusTemplate, lvs octopusLibraryVariableSets) {
+	ots := octopusTenants{}
+	getOctopusData(&ots, apiURL("tenants/all"))
+
+	for _, t := range tenants {
+		envIDs := make([]string, 0)
+		for _, name := range t.Environments {
+			e, _ := getEnvironment(r.environments, name, "")
+			envIDs = append(envIDs, e.ID)
+		}
+
+		ot, err := getTenant(ots, t.Name, "")
+		if err != nil {
+			ot = octopusTenant{
+				Name:                t.Name,
+				ProjectEnvironments: map[string][]string{projectID: envIDs},
+				TenantTags:          make([]string, 0),
+			}
+			postOctopusData(&ot, apiURL("tenants"))
+		} else {
+			if ot.ProjectEnvironments == nil {
+				ot.ProjectEnvironments = make(map[string][]string)
+			}
+			ot.ProjectEnvironments[projectID] = envIDs
+			putOctopusData(&ot, apiURL("tenants/"+ot.ID))
+		}
+
+		if len(t.Variables) != 0 || len(t.EnvironmentVariables) != 0 || len(t.CommonVariables) != 0 {
+			tv := octopusTenantVariables{}
+			getOctopusData(&tv, apiURL("tenants/"+ot.ID+"/variables"))
+
+			pv := tv.ProjectVariables[projectID]
+			pv.ProjectID = projectID
+			if pv.Variables == nil {
+				pv.Variables = make(map[string]map[string]interface{})
+			}
+			for i, env := range t.Environments {
+				if pv.Variables[envIDs[i]] == nil {
+					pv.Variables[envIDs[i]] = make(map[string]interface{})
+				}
+				for name, value := range tenantEnvironmentValues(t, env) {
+					tmpl, _ := getTemplate(templates, name, "")
+					pv.Variables[envIDs[i]][tmpl.ID] = tenantValue(tmpl, value)
+				}
+			}
+			if tv.ProjectVariables == nil {
+				tv.ProjectVariables = make(map[string]octopusTenantProjectVariables)
+			}
+			tv.ProjectVariables[projectID] = pv
+
+			for set, values := range t.CommonVariables {
+				lv, _ := getLibraryVariableSet(lvs, set, "")
+				lib, ok := tv.LibraryVariables[lv.ID]
+				if !ok {
+					logAndExitf("Tenant '%s' can't set values for library variable set '%s' as the project doesn't include it", t.Name, set)
+				}
+				if lib.Variables == nil {
+					lib.Variables = make(map[string]interface{})
+				}
+				for name, value := range values {
+					tmpl, _ := getTemplate(lv.Templates, name, "")
+					lib.Variables[tmpl.ID] = tenantValue(tmpl, value)
+				}
+				tv.LibraryVariables[lv.ID] = lib
+			}
+
+			putOctopusData(&tv, apiURL("tenants/"+ot.ID+"/variables"))
+		}
+		fmt.Println("Put Tenant " + t.Name)
+	}
+}
+
+// exportTenants returns the octopipe.yaml tenants connected to a project. Values the same in every
+// environment are exported as variables, sensitive values are left out
+func (r *processResources) exportTenants(projectID string, included []string) (ts []tenant) {
+	ots := octopusTenants{}
+	getOctopusData(&ots, apiURL("tenants/all"))
+
+	for _, ot := range ots {
+		envIDs, ok := ot.ProjectEnvironments[projectID]
+		if !ok {
+			continue
+		}
+
+		t := tenant{Name: ot.Name, Environments: r.environmentNames(envIDs)}
+
+		tv := octopusTenantVariables{}
+		getOctopusData(&tv, apiURL("tenants/"+ot.ID+"/variables"))
+		pv := tv.ProjectVariables[projectID]
+
+		for _, tmpl := range pv.Templates {
+			values := make(map[string]string)
+			for i, envID := range envIDs {
+				switch value := pv.Variables[envID][tmpl.ID].(type) {
+				case string:
+					values[t.Environments[i]] = escapeValue(value)
+				case nil:
+				default:
+					exportNotice("Tenant '%s' has a sensitive value for '%s' in %s which isn't exported, add it with a secret reference", ot.Name, tmpl.Name, t.Environments[i])
+				}
+			}
+
+			same := len(values) == len(envIDs)
+			for _, value := range values {
+				same = same && value == values[t.Environments[0]]
+			}
+
+			if same && len(values) != 0 {
+				if t.Variables == nil {
+					t.Variables = make(map[string]string)
+				}
+				t.Variables[tmpl.Name] = values[t.Environments[0]]
+				continue
+			}
+			for env, value := range values {
+				if t.EnvironmentVariables == nil {
+					t.EnvironmentVariables = make(map[string]map[string]string)
+				}
+				if t.EnvironmentVariables[env] == nil {
+					t.EnvironmentVariables[env] = make(map[string]string)
+				}
+				t.EnvironmentVariables[env][tmpl.Name] = value
+			}
+		}
+
+		for _, ID := range included {
+			lib, ok := tv.LibraryVariables[ID]
+			if !ok {
+				continue
+			}
+			for _, tmpl := range lib.Templates {
+				if value, ok := lib.Variables[tmpl.ID].(string); ok {
+					if t.CommonVariables == nil {
+						t.CommonVariables = make(map[string]map[string]string)
+					}
+					if t.CommonVariables[lib.Name] == nil {
+						t.CommonVariables[lib.Name] = make(map[string]string)
+					}
+					t.CommonVariables[lib.Name][tmpl.Name] = escapeValue(value)
+				}
+			}
+		}
+
+		ts = append(ts, t)
+	}
+
+	return ts
+}
@@ -0,0 +1,322 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Daily schedules only use the time of day from the start time
+const triggerStartDate = "2019-01-01T"
+
+func (r *processResources) triggerEnvironment(t trigger, name string) string {
+	e, err := getEnvironment(r.environments, name, "")
+	if err != nil {
+		logAndExitf("%s for trigger '%s'", err.Error(), t.Name)
+	}
+
+	return e.ID
+}
+
+func (r *processResources) buildTriggerSchedule(t trigger) (f octopusTriggerFilter) {
+	s := t.Schedule
+	timezone := s.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	switch {
+	case s.Cron != "" && s.Daily != "":
+		logAndExitf("Trigger '%s' can have a cron or a daily schedule, not both", t.Name)
+	case s.Cron != "":
+		if len(s.Days) != 0 {
+			logAndExitf("Trigger '%s' has a cron schedule, days can only be used with a daily schedule", t.Name)
+		}
+		return octopusTriggerFilter{FilterType: "CronExpressionSchedule", CronExpression: s.Cron, Timezone: timezone}
+	case s.Daily != "":
+		if _, err := time.Parse("15:04", s.Daily); err != nil {
+			logAndExitf("Daily schedule '%s' for trigger '%s' is not valid, use the 24 hour time of day such as 02:00", s.Daily, t.Name)
+		}
+		f = octopusTriggerFilter{FilterType: "DailySchedule", StartTime: triggerStartDate + s.Daily + ":00.000Z", Interval: "OnceDaily", Timezone: timezone}
+		if len(s.Days) != 0 {
+			f.FilterType = "DaysPerWeekSchedule"
+			for _, day := range s.Days {
+				if _, err := verifyDayOfWeek(t, day); err != nil {
+					logAndExitf(err.Error())
+				}
+				f.DaysOfWeek = append(f.DaysOfWeek, day)
+			}
+		}
+		return f
+	}
+
+	logAndExitf("Trigger '%s' must have a cron or daily schedule", t.Name)
+	return f
+}
+
+// buildTrigger creates the Octopus project trigger for a trigger in octopipe.yaml
+func (r *processResources) buildTrigger(t trigger) (tt octopusProjectTrigger) {
+	tt = octopusProjectTrigger{
+		Name:        t.Name,
+		Description: t.Description,
+		IsDisabled:  t.Disabled,
+	}
+
+	if (t.Schedule == nil) == (t.Machines == nil) {
+		logAndExitf("Trigger '%s' must have either a schedule or machines", t.Name)
+	}
+
+	if t.Machines != nil {
+		if t.Deploy != nil {
+			logAndExitf("Trigger '%s' deploys to machines as they become available and cannot have deploy", t.Name)
+		}
+
+		tt.Filter = octopusTriggerFilter{FilterType: "MachineFilter", Roles: t.Machines.Roles}
+		for _, name := range t.Machines.Environments {
+			tt.Filter.EnvironmentIds = append(tt.Filter.EnvironmentIds, r.triggerEnvironment(t, name))
+		}
+		events := t.Machines.Events
+		if len(events) == 0 {
+			events = []string{"MachineAvailableForDeployment"}
+		}
+		for _, event := range events {
+			if _, err := verifyTriggerEventGroup(t, event); err != nil {
+				logAndExitf(err.Error())
+			}
+			tt.Filter.EventGroups = append(tt.Filter.EventGroups, event)
+		}
+		tt.Action = octopusTriggerAction{ActionType: "AutoDeploy", ShouldRedeployWhenMachineHasBeenDeployedTo: t.Machines.Redeploy}
+
+		return tt
+	}
+
+	tt.Filter = r.buildTriggerSchedule(t)
+
+	if t.Deploy == nil {
+		logAndExitf("Scheduled trigger '%s' must have deploy", t.Name)
+	}
+	if t.Deploy.Environment == "" {
+		logAndExitf("Scheduled trigger '%s' must deploy to an environment", t.Name)
+	}
+
+	if len(t.Deploy.From) == 0 {
+		tt.Action = octopusTriggerAction{ActionType: "DeployNewRelease", EnvironmentID: r.triggerEnvironment(t, t.Deploy.Environment)}
+	} else {
+		tt.Action = octopusTriggerAction{ActionType: "DeployLatestRelease", DestinationEnvironmentID: r.triggerEnvironment(t, t.Deploy.Environment)}
+		for _, name := range t.Deploy.From {
+			tt.Action.SourceEnvironmentIds = append(tt.Action.SourceEnvironmentIds, r.triggerEnvironment(t, name))
+		}
+	}
+	tt.Action.ShouldRedeployWhenReleaseIsCurrent = t.Deploy.Redeploy
+
+	if t.Deploy.Channel != "" {
+		c, err := getChannel(r.channels.Items, t.Deploy.Channel, "")
+		if err != nil {
+			logAndExitf("%s for trigger '%s'", err.Error(), t.Name)
+		}
+		tt.Action.ChannelID = c.ID
+	}
+
+	for _, name := range t.Deploy.TenantTags {
+		tag, err := getTenantTag(r.tagSets, name)
+		if err != nil {
+			logAndExitf("%s for trigger '%s'", err.Error(), t.Name)
+		}
+		tt.Action.TenantTags = append(tt.Action.TenantTags, tag.CanonicalTagName)
+	}
+
+	return tt
+}
+
+func (r *processResources) buildTriggers(triggers []trigger) (ts []octopusProjectTrigger) {
+	ts = make([]octopusProjectTrigger, 0)
+	for _, t := range triggers {
+		ts = append(ts, r.buildTrigger(t))
+	}
+
+	return ts
+}
+
+func (r *processResources) environmentNames(IDs []string) []string {
+	names := make([]string, 0)
+	for _, ID := range IDs {
+		e, _ := getEnvironment(r.environments, "", ID)
+		names = append(names, e.Name)
+	}
+
+	return names
+}
+
+// describeTriggerFilter describes when a trigger runs, for plans
+func (r *processResources) describeTriggerFilter(f octopusTriggerFilter) string {
+	switch f.FilterType {
+	case "CronExpressionSchedule":
+		return "cron " + f.CronExpression + " (" + f.Timezone + ")"
+	case "DailySchedule", "DaysPerWeekSchedule":
+		days := "daily"
+		if len(f.DaysOfWeek) != 0 {
+			days = strings.Join(f.DaysOfWeek, ", ")
+		}
+		return days + " at " + triggerTime(f.StartTime) + " (" + f.Timezone + ")"
+	case "MachineFilter":
+		d := "machines"
+		if len(f.EnvironmentIds) != 0 {
+			d = d + " in " + strings.Join(r.environmentNames(f.EnvironmentIds), ", ")
+		}
+		if len(f.Roles) != 0 {
+			d = d + " with roles " + strings.Join(f.Roles, ", ")
+		}
+		return d + " on " + strings.Join(f.EventGroups, ", ")
+	}
+
+	return f.FilterType
+}
+
+// describeTriggerAction describes what a trigger deploys, for plans
+func (r *processResources) describeTriggerAction(a octopusTriggerAction) string {
+	var d string
+	switch a.ActionType {
+	case "AutoDeploy":
+		d = "deploy to the machines"
+		if a.ShouldRedeployWhenMachineHasBeenDeployedTo {
+			d = d + ", redeploying"
+		}
+		return d
+	case "DeployNewRelease":
+		e, _ := getEnvironment(r.environments, "", a.EnvironmentID)
+		d = "deploy a new release to " + e.Name
+	case "DeployLatestRelease":
+		e, _ := getEnvironment(r.environments, "", a.DestinationEnvironmentID)
+		d = "deploy the latest release from " + strings.Join(r.environmentNames(a.SourceEnvironmentIds), ", ") + " to " + e.Name
+	default:
+		return a.ActionType
+	}
+
+	if a.ChannelID != "" {
+		c, _ := getChannel(r.channels.Items, "", a.ChannelID)
+		d = d + " in channel " + c.Name
+	}
+	if len(a.TenantTags) != 0 {
+		d = d + " for tenants tagged " + strings.Join(a.TenantTags, ", ")
+	}
+	if a.ShouldRedeployWhenReleaseIsCurrent {
+		d = d + ", redeploying"
+	}
+
+	return d
+}
+
+func (r *processResources) planTrigger(current octopusProjectTrigger, desired octopusProjectTrigger) (changes []planChange) {
+	disabled := func(t octopusProjectTrigger) string {
+		if t.IsDisabled {
+			return "true"
+		}
+		return ""
+	}
+
+	changes = append(changes, planField("Trigger", desired.Name, "Description", current.Description, desired.Description)...)
+	changes = append(changes, planField("Trigger", desired.Name, "Disabled", disabled(current), disabled(desired))...)
+	changes = append(changes, planField("Trigger", desired.Name, "When", r.describeTriggerFilter(current.Filter), r.describeTriggerFilter(desired.Filter))...)
+	changes = append(changes, planField("Trigger", desired.Name, "Action", r.describeTriggerAction(current.Action), r.describeTriggerAction(desired.Action))...)
+
+	return changes
+}
+
+// planTriggers compares triggers by name, triggers in Octopus but not in octopipe.yaml are left alone
+func (r *processResources) planTriggers(current []octopusProjectTrigger, desired []octopusProjectTrigger) (changes []planChange) {
+	for _, t := range desired {
+		et, err := getTrigger(current, t.Name, "")
+		if err != nil {
+			changes = append(changes, withResourceAction(r.planTrigger(octopusProjectTrigger{}, t), "add")...)
+			continue
+		}
+
+		changes = append(changes, withResourceAction(r.planTrigger(et, t), "change")...)
+	}
+
+	return changes
+}
+
+// putTriggers creates or updates the triggers of a project, matching them by name
+func putTriggers(projectID string, triggers []octopusProjectTrigger) {
+	current := octopusProjectTriggers{}
+	getOctopusData(&current, apiURL("projects/"+projectID+"/triggers"))
+
+	for _, t := range triggers {
+		t.ProjectID = projectID
+		if et, err := getTrigger(current.Items, t.Name, ""); err == nil {
+			t.ID = et.ID
+			putOctopusData(&t, apiURL("projecttriggers/"+t.ID))
+		} else {
+			postOctopusData(&t, apiURL("projecttriggers"))
+		}
+		fmt.Println("Put Trigger " + t.Name)
+	}
+}
+
+func triggerTime(startTime string) string {
+	if len(startTime) < 16 {
+		return startTime
+	}
+
+	return startTime[11:16]
+}
+
+// exportTriggers returns the octopipe.yaml triggers for the triggers of a project
+func (r *processResources) exportTriggers(triggers []octopusProjectTrigger) (ts []trigger) {
+	for _, t := range triggers {
+		tt := trigger{
+			Name:        t.Name,
+			Description: t.Description,
+			Disabled:    t.IsDisabled,
+		}
+
+		f := t.Filter
+		timezone := f.Timezone
+		if timezone == "UTC" {
+			timezone = ""
+		}
+		switch f.FilterType {
+		case "CronExpressionSchedule":
+			tt.Schedule = &triggerSchedule{Cron: f.CronExpression, Timezone: timezone}
+		case "DailySchedule", "DaysPerWeekSchedule":
+			tt.Schedule = &triggerSchedule{Daily: triggerTime(f.StartTime), Days: f.DaysOfWeek, Timezone: timezone}
+		case "MachineFilter":
+			tt.Machines = &triggerMachines{
+				Roles:    f.Roles,
+				Redeploy: t.Action.ShouldRedeployWhenMachineHasBeenDeployedTo,
+			}
+			if len(f.EnvironmentIds) != 0 {
+				tt.Machines.Environments = r.environmentNames(f.EnvironmentIds)
+			}
+			if len(f.EventGroups) != 1 || f.EventGroups[0] != "MachineAvailableForDeployment" {
+				tt.Machines.Events = f.EventGroups
+			}
+		default:
+			exportNotice("Trigger '%s' has a %s filter which octopipe can't export, it has been left out", t.Name, f.FilterType)
+			continue
+		}
+
+		a := t.Action
+		switch a.ActionType {
+		case "DeployNewRelease":
+			e, _ := getEnvironment(r.environments, "", a.EnvironmentID)
+			tt.Deploy = &triggerDeploy{Environment: e.Name}
+		case "DeployLatestRelease":
+			e, _ := getEnvironment(r.environments, "", a.DestinationEnvironmentID)
+			tt.Deploy = &triggerDeploy{Environment: e.Name, From: r.environmentNames(a.SourceEnvironmentIds)}
+		}
+		if tt.Deploy != nil {
+			if a.ChannelID != "" {
+				c, _ := getChannel(r.channels.Items, "", a.ChannelID)
+				tt.Deploy.Channel = c.Name
+			}
+			tt.Deploy.TenantTags = a.TenantTags
+			tt.Deploy.Redeploy = a.ShouldRedeployWhenReleaseIsCurrent
+		}
+
+		ts = append(ts, tt)
+	}
+
+	return ts
+}
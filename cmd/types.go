@@ -3,9 +3,11 @@ package cmd
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v2"
@@ -14,46 +16,257 @@ import (
 var apiKey = os.Getenv("OCTOPUS_API_KEY")
 var uri = os.Getenv("OCTOPUS_URI")
 var client = http.Client{}
-var validVariableTypes = []string{"AzureAccount", "AWSAccount", "Certificate", "String"}
+var spaceID string
+var validVariableTypes = []string{"AzureAccount", "AWSAccount", "Certificate", "Sensitive", "String"}
 var validScriptSyntaxTypes = []string{"PowerShell", "Bash", "CSharp", "FSharp"}
 var validTenancyTypes = []string{"Tenanted", "Untenanted", "TenantedOrUntenanted"}
+var validRunOnTypes = []string{"server", "targets"}
+var validConditionTypes = []string{"Success", "Failure", "Always"}
+var validStartTriggerTypes = map[string]string{"wait": "StartAfterPrevious", "parallel": "StartWithPrevious"}
+var validTriggerEventGroups = []string{"Machine", "MachineCritical", "MachineAvailableForDeployment", "MachineUnavailableForDeployment", "MachineHealthChanged"}
+var validDaysOfWeek = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+var validControlTypes = []string{"SingleLineText", "MultiLineText", "Select", "Checkbox", "Sensitive", "Certificate", "AzureAccount", "AmazonWebServicesAccount"}
 var validScopeTypes = []string{"TenantTag", "Environment", "Machine", "Channel", "Action", "Role"}
 
 type project struct {
-	Name         string `yaml:"name"`
-	Description  string `yaml:"description"`
-	ProjectGroup string `yaml:"group"`
-	Lifecycle    string `yaml:"lifecycle"`
-	Tenanted     string `yaml:"tenanted"`
+	Name         string     `yaml:"name"`
+	Description  string     `yaml:"description"`
+	ProjectGroup string     `yaml:"group"`
+	Lifecycle    string     `yaml:"lifecycle"`
+	Tenanted     string     `yaml:"tenanted"`
+	Include      []string   `yaml:"include,omitempty"`
+	Channels     []channel  `yaml:"channels,omitempty"`
+	Templates    []template `yaml:"templates,omitempty"`
+}
+
+// template is a project variable template, tenants are prompted for its value
+type template struct {
+	Name         string           `yaml:"name"`
+	Label        string           `yaml:"label,omitempty"`
+	HelpText     string           `yaml:"helpText,omitempty"`
+	DefaultValue string           `yaml:"defaultValue,omitempty"`
+	ControlType  string           `yaml:"controlType,omitempty"`
+	Options      []templateOption `yaml:"options,omitempty"`
+}
+
+type templateOption struct {
+	Value   string `yaml:"value"`
+	Display string `yaml:"display,omitempty"`
+}
+
+type channel struct {
+	Name        string        `yaml:"name"`
+	Description string        `yaml:"description,omitempty"`
+	Lifecycle   string        `yaml:"lifecycle,omitempty"`
+	IsDefault   bool          `yaml:"isDefault,omitempty"`
+	Rules       []channelRule `yaml:"rules,omitempty"`
+	TenantTags  []string      `yaml:"tenantTags,omitempty"`
+}
+
+// channelRule restricts the versions of packages that releases in a channel can use. Packages are
+// the names of the actions deploying them, or action:package for a named package reference
+type channelRule struct {
+	Packages     []string `yaml:"packages"`
+	VersionRange string   `yaml:"versionRange,omitempty"`
+	Tag          string   `yaml:"tag,omitempty"`
 }
 
 type variable struct {
-	Name         string              `yaml:"name"`
-	Value        string              `yaml:"value,omitempty"`
-	ScopedValues []map[string]string `yaml:"scopedValues,omitempty"`
-	Type         string              `yaml:"type,omitempty"`
-	Description  string              `yaml:"description,omitempty"`
+	Name         string        `yaml:"name"`
+	Value        string        `yaml:"value,omitempty"`
+	ScopedValues []scopedValue `yaml:"scopedValues,omitempty"`
+	Type         string        `yaml:"type,omitempty"`
+	Description  string        `yaml:"description,omitempty"`
+	Sensitive    bool          `yaml:"sensitive,omitempty"`
+	Prompt       *prompt       `yaml:"prompt,omitempty"`
+}
+
+// scopedValue is a value of a variable and its scopes. In octopipe.yaml the scopes sit alongside the
+// value, keyed by scope type, with an optional prompt
+type scopedValue struct {
+	Value  string
+	Scope  map[string]string
+	Prompt *prompt
 }
 
+type prompt struct {
+	Label       string `yaml:"label,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Required    bool   `yaml:"required,omitempty"`
+}
+
+// UnmarshalYAML reads the value and prompt of a scoped value, every other key is a scope
+func (sv *scopedValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	fields := struct {
+		Value  string  `yaml:"value"`
+		Prompt *prompt `yaml:"prompt"`
+	}{}
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+
+	keys := make(map[string]interface{})
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+
+	sv.Value = fields.Value
+	sv.Prompt = fields.Prompt
+	sv.Scope = make(map[string]string)
+	for k, v := range keys {
+		if k == "value" || k == "prompt" {
+			continue
+		}
+		if v != nil {
+			sv.Scope[k] = fmt.Sprint(v)
+		} else {
+			sv.Scope[k] = ""
+		}
+	}
+
+	return nil
+}
+
+// MarshalYAML writes the scopes of a scoped value in order, followed by its value and prompt
+func (sv scopedValue) MarshalYAML() (interface{}, error) {
+	ms := yaml.MapSlice{}
+	for _, k := range sortedKeys(sv.Scope) {
+		ms = append(ms, yaml.MapItem{Key: k, Value: sv.Scope[k]})
+	}
+	if sv.Value != "" {
+		ms = append(ms, yaml.MapItem{Key: "value", Value: sv.Value})
+	}
+	if sv.Prompt != nil {
+		ms = append(ms, yaml.MapItem{Key: "prompt", Value: sv.Prompt})
+	}
+
+	return ms, nil
+}
+
+type action struct {
+	Name         string            `yaml:"name"`
+	ActionType   string            `yaml:"actionType,omitempty"`
+	Type         string            `yaml:"type,omitempty"`
+	File         string            `yaml:"file,omitempty"`
+	AzureAccount string            `yaml:"azureAccount,omitempty"`
+	Packages     []stepPackage     `yaml:"packages,omitempty"`
+	RunOn        string            `yaml:"runOn,omitempty"`
+	WorkerPool   string            `yaml:"workerPool,omitempty"`
+	Environments []string          `yaml:"environments,omitempty"`
+	Excluded     []string          `yaml:"excludedEnvironments,omitempty"`
+	Channels     []string          `yaml:"channels,omitempty"`
+	TenantTags   []string          `yaml:"tenantTags,omitempty"`
+	Properties   map[string]string `yaml:"properties,omitempty"`
+}
+
+// step is a process step, either a single action defined inline or a parent step with child actions
 type step struct {
-	Name string `yaml:"name"`
-	Type string `yaml:"type"`
-	File string `yaml:"file"`
+	action         `yaml:",inline"`
+	Roles          []string `yaml:"roles,omitempty"`
+	Condition      string   `yaml:"condition,omitempty"`
+	StartTrigger   string   `yaml:"startTrigger,omitempty"`
+	MaxParallelism string   `yaml:"maxParallelism,omitempty"`
+	Actions        []action `yaml:"actions,omitempty"`
+}
+
+type stepPackage struct {
+	Name string `yaml:"name,omitempty"`
+	ID   string `yaml:"id"`
+	Feed string `yaml:"feed,omitempty"`
 }
 
 type process struct {
 	Steps []step `yaml:"steps"`
 }
 
+// runbook is a process of the project that is run on demand rather than deployed in a release
+type runbook struct {
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description,omitempty"`
+	Environments []string `yaml:"environments,omitempty"`
+	Steps        []step   `yaml:"steps"`
+}
+
+// trigger is a project trigger, either a schedule that deploys a release or a deployment to
+// machines when they become available
+type trigger struct {
+	Name        string           `yaml:"name"`
+	Description string           `yaml:"description,omitempty"`
+	Disabled    bool             `yaml:"disabled,omitempty"`
+	Schedule    *triggerSchedule `yaml:"schedule,omitempty"`
+	Deploy      *triggerDeploy   `yaml:"deploy,omitempty"`
+	Machines    *triggerMachines `yaml:"machines,omitempty"`
+}
+
+type triggerSchedule struct {
+	Cron     string   `yaml:"cron,omitempty"`
+	Daily    string   `yaml:"daily,omitempty"`
+	Days     []string `yaml:"days,omitempty"`
+	Timezone string   `yaml:"timezone,omitempty"`
+}
+
+type triggerDeploy struct {
+	Environment string   `yaml:"environment"`
+	From        []string `yaml:"from,omitempty"`
+	Channel     string   `yaml:"channel,omitempty"`
+	TenantTags  []string `yaml:"tenantTags,omitempty"`
+	Redeploy    bool     `yaml:"redeploy,omitempty"`
+}
+
+type triggerMachines struct {
+	Environments []string `yaml:"environments,omitempty"`
+	Roles        []string `yaml:"roles,omitempty"`
+	Events       []string `yaml:"events,omitempty"`
+	Redeploy     bool     `yaml:"redeploy,omitempty"`
+}
+
+// tenant connects a tenant to the project and sets its values for the project and library variable set templates.
+// Values in variables are used in every environment, environmentVariables override them per environment
+type tenant struct {
+	Name                 string                       `yaml:"name"`
+	Environments         []string                     `yaml:"environments"`
+	Variables            map[string]string            `yaml:"variables,omitempty"`
+	EnvironmentVariables map[string]map[string]string `yaml:"environmentVariables,omitempty"`
+	CommonVariables      map[string]map[string]string `yaml:"commonVariables,omitempty"`
+}
+
+type libraryVariableSet struct {
+	Name        string     `yaml:"name"`
+	Description string     `yaml:"description,omitempty"`
+	Variables   []variable `yaml:"variables"`
+}
+
+type scriptModule struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Syntax      string `yaml:"syntax"`
+	File        string `yaml:"file"`
+}
+
 type octopipe struct {
+	Space     string     `yaml:"space,omitempty"`
 	Variables []variable `yaml:"variables"`
 	Project   project    `yaml:"project"`
 	Process   process    `yaml:"process"`
+
+	Runbooks            []runbook            `yaml:"runbooks,omitempty"`
+	Triggers            []trigger            `yaml:"triggers,omitempty"`
+	Tenants             []tenant             `yaml:"tenants,omitempty"`
+	LibraryVariableSets []libraryVariableSet `yaml:"libraryVariableSets,omitempty"`
+	ScriptModules       []scriptModule       `yaml:"scriptModules,omitempty"`
 }
 
 type octopusResource interface {
 }
 
+type octopusSpace struct {
+	ID        string `json:"Id"`
+	Name      string `json:"Name"`
+	IsDefault bool   `json:"IsDefault"`
+}
+
+type octopusSpaces []octopusSpace
+
 type octopusLifecycle struct {
 	ID   string `json:"Id"`
 	Name string `json:"Name"`
@@ -68,25 +281,232 @@ type octopusProjectGroup struct {
 
 type octopusProjectGroups []octopusProjectGroup
 
+type octopusFeed struct {
+	ID   string `json:"Id"`
+	Name string `json:"Name"`
+}
+
+type octopusFeeds []octopusFeed
+
+type octopusAccount struct {
+	ID   string `json:"Id"`
+	Name string `json:"Name"`
+}
+
+type octopusAccounts []octopusAccount
+
+type octopusWorkerPool struct {
+	ID        string `json:"Id"`
+	Name      string `json:"Name"`
+	IsDefault bool   `json:"IsDefault"`
+}
+
+type octopusWorkerPools []octopusWorkerPool
+
+type octopusEnvironment struct {
+	ID   string `json:"Id"`
+	Name string `json:"Name"`
+}
+
+type octopusEnvironments []octopusEnvironment
+
+type octopusChannel struct {
+	ID          string               `json:"Id,omitempty"`
+	Name        string               `json:"Name"`
+	Description string               `json:"Description"`
+	ProjectID   string               `json:"ProjectId"`
+	LifecycleID string               `json:"LifecycleId,omitempty"`
+	IsDefault   bool                 `json:"IsDefault"`
+	Rules       []octopusChannelRule `json:"Rules"`
+	TenantTags  []string             `json:"TenantTags"`
+}
+
+type octopusChannelRule struct {
+	ID             string                 `json:"Id,omitempty"`
+	VersionRange   string                 `json:"VersionRange"`
+	Tag            string                 `json:"Tag"`
+	ActionPackages []octopusActionPackage `json:"ActionPackages"`
+}
+
+type octopusActionPackage struct {
+	DeploymentAction string `json:"DeploymentAction"`
+	PackageReference string `json:"PackageReference"`
+}
+
+type octopusChannels struct {
+	Items []octopusChannel `json:"Items"`
+}
+
+type octopusProjectTrigger struct {
+	ID          string               `json:"Id,omitempty"`
+	Name        string               `json:"Name"`
+	Description string               `json:"Description"`
+	ProjectID   string               `json:"ProjectId"`
+	IsDisabled  bool                 `json:"IsDisabled"`
+	Filter      octopusTriggerFilter `json:"Filter"`
+	Action      octopusTriggerAction `json:"Action"`
+}
+
+type octopusTriggerFilter struct {
+	FilterType     string   `json:"FilterType"`
+	CronExpression string   `json:"CronExpression,omitempty"`
+	StartTime      string   `json:"StartTime,omitempty"`
+	Interval       string   `json:"Interval,omitempty"`
+	DaysOfWeek     []string `json:"DaysOfWeek,omitempty"`
+	Timezone       string   `json:"Timezone,omitempty"`
+	EnvironmentIds []string `json:"EnvironmentIds,omitempty"`
+	Roles          []string `json:"Roles,omitempty"`
+	EventGroups    []string `json:"EventGroups,omitempty"`
+}
+
+type octopusTriggerAction struct {
+	ActionType                                 string   `json:"ActionType"`
+	EnvironmentID                              string   `json:"EnvironmentId,omitempty"`
+	SourceEnvironmentIds                       []string `json:"SourceEnvironmentIds,omitempty"`
+	DestinationEnvironmentID                   string   `json:"DestinationEnvironmentId,omitempty"`
+	ChannelID                                  string   `json:"ChannelId,omitempty"`
+	TenantTags                                 []string `json:"TenantTags,omitempty"`
+	ShouldRedeployWhenReleaseIsCurrent         bool     `json:"ShouldRedeployWhenReleaseIsCurrent"`
+	ShouldRedeployWhenMachineHasBeenDeployedTo bool     `json:"ShouldRedeployWhenMachineHasBeenDeployedTo"`
+}
+
+type octopusProjectTriggers struct {
+	Items []octopusProjectTrigger `json:"Items"`
+}
+
+type octopusTag struct {
+	ID               string `json:"Id"`
+	Name             string `json:"Name"`
+	CanonicalTagName string `json:"CanonicalTagName"`
+}
+
+type octopusTagSet struct {
+	ID   string       `json:"Id"`
+	Name string       `json:"Name"`
+	Tags []octopusTag `json:"Tags"`
+}
+
+type octopusTagSets []octopusTagSet
+
 type octopusProject struct {
-	ID                     string            `json:"Id"`
-	Name                   string            `json:"Name"`
-	Description            string            `json:"Description"`
-	VariableSetID          string            `json:"VariableSetId"`
-	LifecycleID            string            `json:"LifecycleId"`
-	ProjectGroupID         string            `json:"ProjectGroupId"`
-	DeploymentProcessID    string            `json:"DeploymentProcessId"`
-	TenantedDeploymentMode string            `json:"TenantedDeploymentMode"`
-	Links                  map[string]string `json:"Links"`
+	ID                            string            `json:"Id"`
+	Name                          string            `json:"Name"`
+	Slug                          string            `json:"Slug,omitempty"`
+	Description                   string            `json:"Description"`
+	VariableSetID                 string            `json:"VariableSetId"`
+	LifecycleID                   string            `json:"LifecycleId"`
+	ProjectGroupID                string            `json:"ProjectGroupId"`
+	DeploymentProcessID           string            `json:"DeploymentProcessId"`
+	TenantedDeploymentMode        string            `json:"TenantedDeploymentMode"`
+	IncludedLibraryVariableSetIds []string          `json:"IncludedLibraryVariableSetIds"`
+	Templates                     []octopusTemplate `json:"Templates"`
+	Links                         map[string]string `json:"Links"`
+}
+
+type octopusProjects []octopusProject
+
+// octopusProjectSettings are the settings of a project octopipe.yaml doesn't have, read by export
+// to report the ones that are not the default
+type octopusProjectSettings struct {
+	IsDisabled                      bool   `json:"IsDisabled"`
+	DefaultGuidedFailureMode        string `json:"DefaultGuidedFailureMode"`
+	DefaultToSkipIfAlreadyInstalled bool   `json:"DefaultToSkipIfAlreadyInstalled"`
+	AutoCreateRelease               bool   `json:"AutoCreateRelease"`
+	DiscreteChannelRelease          bool   `json:"DiscreteChannelRelease"`
+	ReleaseNotesTemplate            string `json:"ReleaseNotesTemplate"`
+	VersioningStrategy              struct {
+		Template     string                `json:"Template"`
+		DonorPackage *octopusActionPackage `json:"DonorPackage"`
+	} `json:"VersioningStrategy"`
+	ProjectConnectivityPolicy struct {
+		SkipMachineBehavior         string   `json:"SkipMachineBehavior"`
+		TargetRoles                 []string `json:"TargetRoles"`
+		AllowDeploymentsToNoTargets bool     `json:"AllowDeploymentsToNoTargets"`
+		ExcludeUnhealthyTargets     bool     `json:"ExcludeUnhealthyTargets"`
+	} `json:"ProjectConnectivityPolicy"`
+}
+
+type octopusLibraryVariableSet struct {
+	ID            string            `json:"Id,omitempty"`
+	Name          string            `json:"Name"`
+	Description   string            `json:"Description"`
+	VariableSetID string            `json:"VariableSetId,omitempty"`
+	ContentType   string            `json:"ContentType"`
+	Templates     []octopusTemplate `json:"Templates,omitempty"`
+}
+
+// octopusTemplate is a variable template on a project or library variable set, tenants provide
+// its value. Sensitive values are objects rather than strings so values are left as interface{}
+type octopusTemplate struct {
+	ID              string            `json:"Id,omitempty"`
+	Name            string            `json:"Name"`
+	Label           string            `json:"Label"`
+	HelpText        string            `json:"HelpText"`
+	DefaultValue    interface{}       `json:"DefaultValue"`
+	DisplaySettings map[string]string `json:"DisplaySettings"`
 }
 
+type octopusTenant struct {
+	ID                  string              `json:"Id,omitempty"`
+	Name                string              `json:"Name"`
+	Description         string              `json:"Description"`
+	ProjectEnvironments map[string][]string `json:"ProjectEnvironments"`
+	TenantTags          []string            `json:"TenantTags"`
+}
+
+type octopusTenants []octopusTenant
+
+type octopusTenantVariables struct {
+	TenantID         string                                   `json:"TenantId"`
+	ProjectVariables map[string]octopusTenantProjectVariables `json:"ProjectVariables"`
+	LibraryVariables map[string]octopusTenantLibraryVariables `json:"LibraryVariables"`
+}
+
+type octopusTenantProjectVariables struct {
+	ProjectID   string                            `json:"ProjectId"`
+	ProjectName string                            `json:"ProjectName"`
+	Templates   []octopusTemplate                 `json:"Templates"`
+	Variables   map[string]map[string]interface{} `json:"Variables"`
+}
+
+type octopusTenantLibraryVariables struct {
+	LibraryVariableSetID string                 `json:"LibraryVariableSetId"`
+	Name                 string                 `json:"Name"`
+	Templates            []octopusTemplate      `json:"Templates"`
+	Variables            map[string]interface{} `json:"Variables"`
+}
+
+type octopusLibraryVariableSets []octopusLibraryVariableSet
+
 type octopusVariable struct {
-	Name        string              `json:"Name"`
-	Value       string              `json:"Value"`
-	Description string              `json:"Description"`
-	IsSensitive bool                `json:"IsSensitive"`
-	Scope       map[string][]string `json:"Scope,omitempty"`
-	Type        string              `json:"Type"`
+	ID          string                 `json:"Id,omitempty"`
+	Name        string                 `json:"Name"`
+	Value       string                 `json:"Value"`
+	Description string                 `json:"Description"`
+	IsSensitive bool                   `json:"IsSensitive"`
+	Scope       map[string][]string    `json:"Scope,omitempty"`
+	Type        string                 `json:"Type"`
+	Prompt      *octopusVariablePrompt `json:"Prompt"`
+}
+
+type octopusVariablePrompt struct {
+	Label           string            `json:"Label"`
+	Description     string            `json:"Description"`
+	Required        bool              `json:"Required"`
+	DisplaySettings map[string]string `json:"DisplaySettings,omitempty"`
+}
+
+// MarshalJSON sends the value of a sensitive variable as null when it is not set, so Octopus keeps its existing value
+func (v octopusVariable) MarshalJSON() ([]byte, error) {
+	type plainVariable octopusVariable
+	if v.IsSensitive && v.Value == "" {
+		return json.Marshal(struct {
+			plainVariable
+			Value *string `json:"Value"`
+		}{plainVariable: plainVariable(v)})
+	}
+
+	return json.Marshal(plainVariable(v))
 }
 
 type octopusVariableSetScopeValue struct {
@@ -112,16 +532,101 @@ type octopusVariableSet struct {
 	ScopeValues octopusVariableSetScopeValues `json:"ScopeValues"`
 }
 
+type octopusPackageReference struct {
+	ID                  string            `json:"Id,omitempty"`
+	Name                string            `json:"Name"`
+	PackageID           string            `json:"PackageId"`
+	FeedID              string            `json:"FeedId"`
+	AcquisitionLocation string            `json:"AcquisitionLocation"`
+	Properties          map[string]string `json:"Properties"`
+}
+
 type octopusDeploymentAction struct {
-	Name         string            `json:"Name"`
-	ActionType   string            `json:"ActionType"`
-	WorkerPoolID string            `json:"WorkerPoolId"`
-	Properties   map[string]string `json:"Properties"`
+	ID                   string                    `json:"Id,omitempty"`
+	Name                 string                    `json:"Name"`
+	ActionType           string                    `json:"ActionType"`
+	WorkerPoolID         string                    `json:"WorkerPoolId"`
+	Environments         []string                  `json:"Environments"`
+	ExcludedEnvironments []string                  `json:"ExcludedEnvironments"`
+	Channels             []string                  `json:"Channels"`
+	TenantTags           []string                  `json:"TenantTags"`
+	Properties           map[string]string         `json:"Properties"`
+	Packages             []octopusPackageReference `json:"Packages"`
+	IsDisabled           bool                      `json:"IsDisabled,omitempty"`
+	IsRequired           bool                      `json:"IsRequired,omitempty"`
+	Notes                string                    `json:"Notes,omitempty"`
+	WorkerPoolVariable   string                    `json:"WorkerPoolVariable,omitempty"`
+	Container            *octopusActionContainer   `json:"Container,omitempty"`
+	sensitiveProperties  []string
+}
+
+type octopusActionContainer struct {
+	Image  string `json:"Image"`
+	FeedID string `json:"FeedId"`
 }
 
+// UnmarshalJSON reads an action from Octopus. Sensitive property values are objects rather than
+// strings, their names are kept in sensitiveProperties and they are left out of Properties
+func (a *octopusDeploymentAction) UnmarshalJSON(b []byte) error {
+	type plainAction octopusDeploymentAction
+	err := json.Unmarshal(b, (*plainAction)(a))
+	if _, ok := err.(*json.UnmarshalTypeError); err != nil && !ok {
+		return err
+	}
+
+	raw := struct {
+		Properties map[string]json.RawMessage `json:"Properties"`
+	}{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	a.sensitiveProperties = nil
+	for k, v := range raw.Properties {
+		if len(v) != 0 && v[0] == '{' {
+			a.sensitiveProperties = append(a.sensitiveProperties, k)
+			delete(a.Properties, k)
+		}
+	}
+	sort.Strings(a.sensitiveProperties)
+
+	return nil
+}
+
+// octopusDeploymentStep keeps the json of steps read from Octopus, so steps octopipe.yaml doesn't
+// manage are put back unchanged with the fields and sensitive properties octopipe doesn't model
 type octopusDeploymentStep struct {
-	Name    string                    `json:"Name"`
-	Actions []octopusDeploymentAction `json:"Actions"`
+	ID                 string                    `json:"Id,omitempty"`
+	Name               string                    `json:"Name"`
+	Condition          string                    `json:"Condition,omitempty"`
+	StartTrigger       string                    `json:"StartTrigger,omitempty"`
+	Properties         map[string]string         `json:"Properties"`
+	Actions            []octopusDeploymentAction `json:"Actions"`
+	PackageRequirement string                    `json:"PackageRequirement,omitempty"`
+	raw                json.RawMessage
+}
+
+// UnmarshalJSON reads a step from Octopus and keeps its json. Sensitive property values are
+// objects that can't be read into Properties, they are left out of the fields but kept in the json
+func (s *octopusDeploymentStep) UnmarshalJSON(b []byte) error {
+	type plainStep octopusDeploymentStep
+	err := json.Unmarshal(b, (*plainStep)(s))
+	if _, ok := err.(*json.UnmarshalTypeError); err != nil && !ok {
+		return err
+	}
+
+	s.raw = append(json.RawMessage{}, b...)
+	return nil
+}
+
+// MarshalJSON writes a step read from Octopus as it was read, and a step built from octopipe.yaml
+// from its fields
+func (s octopusDeploymentStep) MarshalJSON() ([]byte, error) {
+	if s.raw != nil {
+		return s.raw, nil
+	}
+
+	type plainStep octopusDeploymentStep
+	return json.Marshal(plainStep(s))
 }
 
 type octopusDeploymentProcess struct {
@@ -154,6 +659,35 @@ func putOctopusData(o octopusResource, uri string) {
 	json.Unmarshal(responsebody, o)
 }
 
+// putOctopusFields puts only the named json fields of a resource. Every other field keeps the value
+// Octopus has, so settings octopipe doesn't model aren't reset
+func putOctopusFields(o octopusResource, uri string, fields ...string) {
+	current := make(map[string]json.RawMessage)
+	getOctopusData(&current, uri)
+
+	body, err := json.Marshal(o)
+	if err != nil {
+		logAndExitf("Failed to serialize Octopus resource before put:\n%s", err.Error())
+	}
+	desired := make(map[string]json.RawMessage)
+	json.Unmarshal(body, &desired)
+	for _, f := range fields {
+		current[f] = desired[f]
+	}
+
+	body, err = json.Marshal(current)
+	if err != nil {
+		logAndExitf("Failed to serialize Octopus resource before put:\n%s", err.Error())
+	}
+	responsebody, status := doOctopusRequest(body, uri, "PUT")
+
+	if status != 200 {
+		logAndExitf("Failed to put Octopus resource:\n%s", string(responsebody))
+	}
+
+	json.Unmarshal(responsebody, o)
+}
+
 func postOctopusData(o octopusResource, uri string) {
 	body, err := json.Marshal(o)
 	if err != nil {
@@ -168,6 +702,124 @@ func postOctopusData(o octopusResource, uri string) {
 	json.Unmarshal(responsebody, o)
 }
 
+type octopusReleaseTemplate struct {
+	NextVersionIncrement string                          `json:"NextVersionIncrement"`
+	Packages             []octopusReleaseTemplatePackage `json:"Packages"`
+}
+
+type octopusReleaseTemplatePackage struct {
+	ActionName                 string `json:"ActionName"`
+	PackageReferenceName       string `json:"PackageReferenceName"`
+	PackageID                  string `json:"PackageId"`
+	FeedID                     string `json:"FeedId"`
+	IsResolvable               bool   `json:"IsResolvable"`
+	VersionSelectedLastRelease string `json:"VersionSelectedLastRelease"`
+}
+
+type octopusPackageVersion struct {
+	Version string `json:"Version"`
+}
+
+type octopusPackageVersions struct {
+	Items []octopusPackageVersion `json:"Items"`
+}
+
+type octopusSelectedPackage struct {
+	ActionName           string `json:"ActionName"`
+	PackageReferenceName string `json:"PackageReferenceName"`
+	Version              string `json:"Version"`
+}
+
+type octopusRelease struct {
+	ID               string                   `json:"Id,omitempty"`
+	ProjectID        string                   `json:"ProjectId"`
+	ChannelID        string                   `json:"ChannelId"`
+	Version          string                   `json:"Version"`
+	SelectedPackages []octopusSelectedPackage `json:"SelectedPackages"`
+}
+
+type octopusDeployment struct {
+	ID            string `json:"Id,omitempty"`
+	ReleaseID     string `json:"ReleaseId"`
+	EnvironmentID string `json:"EnvironmentId"`
+	TenantID      string `json:"TenantId,omitempty"`
+	TaskID        string `json:"TaskId,omitempty"`
+}
+
+type octopusRunbookRun struct {
+	ID                string `json:"Id,omitempty"`
+	RunbookID         string `json:"RunbookId"`
+	RunbookSnapshotID string `json:"RunbookSnapshotId"`
+	EnvironmentID     string `json:"EnvironmentId"`
+	TenantID          string `json:"TenantId,omitempty"`
+	TaskID            string `json:"TaskId,omitempty"`
+}
+
+type octopusTask struct {
+	ID                   string `json:"Id"`
+	Description          string `json:"Description"`
+	State                string `json:"State"`
+	IsCompleted          bool   `json:"IsCompleted"`
+	FinishedSuccessfully bool   `json:"FinishedSuccessfully"`
+	ErrorMessage         string `json:"ErrorMessage"`
+}
+
+type octopusTaskDetails struct {
+	Task         octopusTask              `json:"Task"`
+	ActivityLogs []octopusActivityElement `json:"ActivityLogs"`
+}
+
+type octopusActivityElement struct {
+	ID          string                      `json:"Id"`
+	Name        string                      `json:"Name"`
+	Status      string                      `json:"Status"`
+	Children    []octopusActivityElement    `json:"Children"`
+	LogElements []octopusActivityLogElement `json:"LogElements"`
+}
+
+type octopusActivityLogElement struct {
+	Category    string `json:"Category"`
+	MessageText string `json:"MessageText"`
+	OccurredAt  string `json:"OccurredAt"`
+}
+
+type octopusRunbook struct {
+	ID                         string   `json:"Id,omitempty"`
+	Name                       string   `json:"Name"`
+	Description                string   `json:"Description"`
+	ProjectID                  string   `json:"ProjectId"`
+	RunbookProcessID           string   `json:"RunbookProcessId,omitempty"`
+	PublishedRunbookSnapshotID string   `json:"PublishedRunbookSnapshotId,omitempty"`
+	MultiTenancyMode           string   `json:"MultiTenancyMode,omitempty"`
+	EnvironmentScope           string   `json:"EnvironmentScope"`
+	Environments               []string `json:"Environments"`
+}
+
+type octopusRunbooks struct {
+	Items []octopusRunbook `json:"Items"`
+}
+
+type octopusRunbookProcess struct {
+	ID        string                  `json:"Id"`
+	RunbookID string                  `json:"RunbookId"`
+	ProjectID string                  `json:"ProjectId"`
+	Version   int                     `json:"Version"`
+	Steps     []octopusDeploymentStep `json:"Steps"`
+}
+
+type octopusRunbookSnapshotTemplate struct {
+	NextNameIncrement string                          `json:"NextNameIncrement"`
+	Packages          []octopusReleaseTemplatePackage `json:"Packages"`
+}
+
+type octopusRunbookSnapshot struct {
+	ID               string                   `json:"Id,omitempty"`
+	ProjectID        string                   `json:"ProjectId"`
+	RunbookID        string                   `json:"RunbookId"`
+	Name             string                   `json:"Name"`
+	SelectedPackages []octopusSelectedPackage `json:"SelectedPackages"`
+}
+
 func (op *octopipe) importOctopipeFile() {
 
 	ofile, err := ioutil.ReadFile("octopipe.yaml")
@@ -236,3 +888,74 @@ func (v *octopusVariableSetScopeValues) getScope(dataset map[string][]octopusVar
 
 	return nil, nil, errors.New("No names or Ids to process")
 }
+
+// resolveVariableScopes converts the scope names of variables built by flattenVariables into Octopus Ids
+func (v *octopusVariableSet) resolveVariableScopes(vars []octopusVariable) (newv []octopusVariable) {
+	ds := v.ScopeValues.makeScopeDataSet()
+	newv = make([]octopusVariable, 0)
+
+	for _, tv := range vars {
+		if tv.Scope != nil {
+			scopes := make(map[string][]string)
+			for stype, names := range tv.Scope {
+				scopeIDs, _, err := v.ScopeValues.getScope(ds, strings.Join(names, ","), nil, stype)
+				if err != nil {
+					logAndExitf(err.Error())
+				}
+				scopes[stype] = scopeIDs
+			}
+			tv.Scope = scopes
+		}
+		newv = append(newv, tv)
+	}
+
+	return newv
+}
+
+// namedVariables returns the variables in the set with their scope Ids converted to names
+func (v *octopusVariableSet) namedVariables() (newv []octopusVariable) {
+	ds := v.ScopeValues.makeScopeDataSet()
+	newv = make([]octopusVariable, 0)
+
+	for _, tv := range v.Variables {
+		if len(tv.Scope) != 0 {
+			scopes := make(map[string][]string)
+			for stype, IDs := range tv.Scope {
+				_, scopeNames, _ := v.ScopeValues.getScope(ds, "", IDs, stype)
+				scopes[stype] = scopeNames
+			}
+			tv.Scope = scopes
+		} else {
+			tv.Scope = nil
+		}
+		newv = append(newv, tv)
+	}
+
+	return newv
+}
+
+// keepSensitiveValues links sensitive variables without a value in octopipe.yaml to the
+// matching variable in the set, so Octopus keeps the value it already has.
+// Scopes must have been resolved to Ids
+func (v *octopusVariableSet) keepSensitiveValues(vars []octopusVariable) (newv []octopusVariable) {
+	existing := make(map[string]octopusVariable)
+	for _, tv := range v.Variables {
+		if tv.IsSensitive {
+			existing[variableKey(tv)] = tv
+		}
+	}
+
+	newv = make([]octopusVariable, 0)
+	for _, tv := range vars {
+		if tv.IsSensitive && tv.Value == "" {
+			if ev, ok := existing[variableKey(tv)]; ok {
+				tv.ID = ev.ID
+			} else {
+				fmt.Printf("Sensitive variable '%s' has no value in octopipe.yaml or Octopus, set its value in Octopus\n", variableKey(tv))
+			}
+		}
+		newv = append(newv, tv)
+	}
+
+	return newv
+}